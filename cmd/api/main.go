@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/kartex/imageprovider/internal/handlers"
+	"github.com/kartex/imageprovider/internal/index"
 	"github.com/kartex/imageprovider/internal/middleware"
 	"github.com/kartex/imageprovider/internal/services"
 	"github.com/kartex/imageprovider/internal/storage"
@@ -44,8 +45,23 @@ func main() {
 		}
 	}
 
+	// Open the metadata index; the service falls back to storage listings without it
+	indexPath := os.Getenv("INDEX_PATH")
+	if indexPath == "" {
+		indexPath = "./index.db"
+	}
+	metaIndex, err := index.Open(indexPath)
+	if err != nil {
+		log.Printf("Warning: Failed to open metadata index: %v", err)
+	} else {
+		defer metaIndex.Close()
+	}
+
 	// Initialize image service
-	imageService := services.NewImageService(fileStorage, s3Storage)
+	imageService := services.NewImageService(fileStorage, s3Storage, metaIndex)
+
+	// Continue a re-encode job interrupted by a restart
+	imageService.RestoreReencode()
 
 	// Initialize handlers
 	imageHandler := handlers.NewImageHandler(imageService)
@@ -70,15 +86,36 @@ func main() {
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
-	router.GET("/images/:id", imageHandler.GetImage)
+	signed := middleware.SignedURLMiddleware()
+	router.GET("/images/:id", signed, imageHandler.GetImage)
+	router.GET("/images/:id/info", imageHandler.GetImageInfo)
+	router.GET("/images/:id/placeholder", imageHandler.GetPlaceholder)
+	router.GET("/images/:id/colors", imageHandler.GetColors)
+	router.GET("/t/*path", signed, imageHandler.TransformImage)
+	router.GET("/p/:preset/:id", signed, imageHandler.PresetImage)
+	router.GET("/presets", imageHandler.ListPresets)
+	router.GET("/formats", imageHandler.ListFormats)
 
 	// Protected routes
 	protected := router.Group("")
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.POST("/images", imageHandler.CreateImage)
+		protected.POST("/images/batch", imageHandler.CreateImages)
+		protected.PATCH("/images/:id", imageHandler.UpdateImage)
 		protected.DELETE("/images/:id", imageHandler.DeleteImage)
+		protected.GET("/images/:id/original", imageHandler.GetOriginal)
+		protected.GET("/images/:id/similar", imageHandler.GetSimilar)
+		protected.GET("/images/:id/srcset", imageHandler.GetSrcset)
 		protected.GET("/images", imageHandler.ListImages)
+		protected.GET("/images/export", imageHandler.ExportImages)
+		protected.GET("/policies", imageHandler.ListPolicies)
+		protected.GET("/stats", imageHandler.GetStats)
+		protected.POST("/jobs/reencode", imageHandler.StartReencode)
+		protected.GET("/jobs/reencode", imageHandler.GetReencode)
+		protected.POST("/jobs/reencode/pause", imageHandler.PauseReencode)
+		protected.POST("/jobs/reencode/resume", imageHandler.ResumeReencode)
+		protected.DELETE("/jobs/reencode", imageHandler.CancelReencode)
 	}
 
 	// Start server
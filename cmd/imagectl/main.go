@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/kartex/imageprovider/internal/index"
+	"github.com/kartex/imageprovider/internal/services"
+	"github.com/kartex/imageprovider/internal/storage"
+	"github.com/kartex/imageprovider/pkg/urlsign"
+)
+
+const usage = `Usage: imagectl <command>
+
+Commands:
+  reindex   Rebuild the metadata index from storage
+  check     Compare the metadata index with storage (exit status 1 on differences)
+  sign      Print a signed URL: imagectl sign [-kid id] [-ttl 24h] <url-or-path>
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "reindex":
+		runReindex()
+	case "check":
+		runCheck()
+	case "sign":
+		runSign(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runReindex() {
+	imageService, closeIndex := newImageService()
+	defer closeIndex()
+
+	report, err := imageService.Reindex()
+	if err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+	printJSON(report)
+}
+
+func runCheck() {
+	imageService, closeIndex := newImageService()
+
+	report, err := imageService.CheckIndex()
+	closeIndex()
+	if err != nil {
+		log.Fatalf("Consistency check failed: %v", err)
+	}
+	printJSON(report)
+	if !report.Consistent() {
+		os.Exit(1)
+	}
+}
+
+func runSign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	kid := flags.String("kid", "", "key ID to sign with (default: first key in URL_SIGNING_KEYS)")
+	ttl := flags.Duration("ttl", 0, "validity period, e.g. 24h (default: no expiry)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	keys, err := urlsign.ParseKeys(os.Getenv("URL_SIGNING_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid URL_SIGNING_KEYS: %v", err)
+	}
+	if len(keys) == 0 {
+		log.Fatal("URL_SIGNING_KEYS is not set")
+	}
+
+	key := keys[0]
+	if *kid != "" {
+		found := false
+		for _, k := range keys {
+			if k.ID == *kid {
+				key, found = k, true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("Unknown key ID %q", *kid)
+		}
+	}
+
+	var expires time.Time
+	if *ttl > 0 {
+		expires = time.Now().Add(*ttl)
+	}
+
+	signed, err := urlsign.Sign(key, flags.Arg(0), expires)
+	if err != nil {
+		log.Fatalf("Failed to sign URL: %v", err)
+	}
+	fmt.Println(signed)
+}
+
+// newImageService wires storage and the index the same way the API server
+// does. The index file is locked, so the server must be stopped first.
+func newImageService() (*services.ImageService, func()) {
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "./data"
+	}
+	fileStorage, err := storage.NewFileSystemStorage(storagePath)
+	if err != nil {
+		log.Fatalf("Failed to initialize file storage: %v", err)
+	}
+
+	var s3Storage storage.Storage
+	if os.Getenv("S3_ENDPOINT") != "" {
+		s3, err := storage.NewS3Storage()
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 storage: %v", err)
+		}
+		s3Storage = s3
+	}
+
+	indexPath := os.Getenv("INDEX_PATH")
+	if indexPath == "" {
+		indexPath = "./index.db"
+	}
+	metaIndex, err := index.Open(indexPath)
+	if err != nil {
+		log.Fatalf("Failed to open metadata index: %v", err)
+	}
+
+	return services.NewImageService(fileStorage, s3Storage, metaIndex), func() { metaIndex.Close() }
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package animation decodes animated GIFs and reads and writes animated WebP.
+//
+// Frames are kept as full-canvas images, already composited, so transforms
+// can run on each frame independently. Animated WebP files are written with
+// one full-canvas frame per ANMF chunk and read back by compositing frames
+// according to their blend and dispose flags.
+package animation
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+
+	"golang.org/x/image/draw"
+)
+
+var ErrInvalidAnimation = errors.New("invalid animation")
+
+// minDelay is the delay used for frames that declare 10ms or less, which
+// browsers also treat as 100ms.
+const minDelay = 100
+
+// Frame is one composited frame and its display time in milliseconds.
+type Frame struct {
+	Image    *image.RGBA
+	Duration int
+}
+
+// Animation is a sequence of full-canvas frames. LoopCount 0 loops forever.
+type Animation struct {
+	Width     int
+	Height    int
+	Frames    []Frame
+	LoopCount int
+}
+
+// Duration returns the total display time in milliseconds.
+func (a *Animation) Duration() int {
+	total := 0
+	for _, f := range a.Frames {
+		total += f.Duration
+	}
+	return total
+}
+
+// Map returns a new animation with fn applied to every frame. The canvas
+// size is taken from the first transformed frame.
+func (a *Animation) Map(fn func(image.Image) image.Image) *Animation {
+	out := &Animation{LoopCount: a.LoopCount, Frames: make([]Frame, len(a.Frames))}
+	for i, f := range a.Frames {
+		out.Frames[i] = Frame{Image: toRGBA(fn(f.Image)), Duration: f.Duration}
+	}
+	if len(out.Frames) > 0 {
+		b := out.Frames[0].Image.Bounds()
+		out.Width, out.Height = b.Dx(), b.Dy()
+	}
+	return out
+}
+
+// FromGIF composites the frames of a decoded GIF, honoring each frame's
+// disposal method.
+func FromGIF(g *gif.GIF) (*Animation, error) {
+	w, h := g.Config.Width, g.Config.Height
+	if w == 0 || h == 0 {
+		for _, p := range g.Image {
+			w = max(w, p.Bounds().Max.X)
+			h = max(h, p.Bounds().Max.Y)
+		}
+	}
+	if w == 0 || h == 0 || len(g.Image) == 0 {
+		return nil, ErrInvalidAnimation
+	}
+
+	a := &Animation{Width: w, Height: h}
+	switch {
+	case g.LoopCount == 0:
+		a.LoopCount = 0
+	case g.LoopCount < 0:
+		a.LoopCount = 1
+	default:
+		a.LoopCount = g.LoopCount + 1 // GIF counts repeats, WebP counts plays
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, p := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i] * 10
+		}
+		if delay <= 10 {
+			delay = minDelay
+		}
+		a.Frames = append(a.Frames, Frame{Image: cloneRGBA(canvas), Duration: delay})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a, nil
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Copy(dst, image.Point{}, img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,191 @@
+package animation
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	pal   = color.Palette{color.Transparent, red, blue, green, white}
+)
+
+func gifFrame(r image.Rectangle, c color.Color) *image.Paletted {
+	p := image.NewPaletted(r, pal)
+	idx := uint8(pal.Index(c))
+	for i := range p.Pix {
+		p.Pix[i] = idx
+	}
+	return p
+}
+
+// disposalGIF is a 4x4 red background followed by single pixels using each
+// disposal method.
+func disposalGIF() *gif.GIF {
+	return &gif.GIF{
+		Config: image.Config{Width: 4, Height: 4, ColorModel: pal},
+		Image: []*image.Paletted{
+			gifFrame(image.Rect(0, 0, 4, 4), red),
+			gifFrame(image.Rect(0, 0, 1, 1), blue),
+			gifFrame(image.Rect(3, 3, 4, 4), green),
+			gifFrame(image.Rect(1, 1, 2, 2), white),
+		},
+		Delay:     []int{0, 1, 20, 50},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 2,
+	}
+}
+
+func TestFromGIFDisposal(t *testing.T) {
+	a, err := FromGIF(disposalGIF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Width != 4 || a.Height != 4 || len(a.Frames) != 4 || a.LoopCount != 3 {
+		t.Fatalf("animation = %dx%d, %d frames, loop %d", a.Width, a.Height, len(a.Frames), a.LoopCount)
+	}
+
+	tests := []struct {
+		frame int
+		x, y  int
+		want  color.RGBA
+	}{
+		{1, 0, 0, blue},
+		{2, 0, 0, red},          // restored by DisposalPrevious
+		{2, 3, 3, green},        // drawn
+		{3, 3, 3, color.RGBA{}}, // cleared by DisposalBackground
+		{3, 1, 1, white},
+		{3, 0, 0, red},
+	}
+	for _, tt := range tests {
+		if got := a.Frames[tt.frame].Image.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("frame %d (%d,%d) = %v, want %v", tt.frame, tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// Delays of 10ms or less play at 100ms, as in browsers
+	for i, want := range []int{100, 100, 200, 500} {
+		if d := a.Frames[i].Duration; d != want {
+			t.Errorf("frame %d duration = %d, want %d", i, d, want)
+		}
+	}
+}
+
+func TestWebPRoundTrip(t *testing.T) {
+	a, err := FromGIF(disposalGIF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := EncodeWebP(buf, a, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := Probe(buf.Bytes())
+	if !ok || info.Width != 4 || info.Height != 4 || info.Frames != 4 || info.Duration != a.Duration() {
+		t.Fatalf("Probe = %+v, %v", info, ok)
+	}
+
+	decoded, err := DecodeWebP(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LoopCount != a.LoopCount || len(decoded.Frames) != len(a.Frames) {
+		t.Fatalf("decoded loop %d with %d frames", decoded.LoopCount, len(decoded.Frames))
+	}
+	// Lossless frames come back exactly, transparency included
+	for i, f := range decoded.Frames {
+		if f.Duration != a.Frames[i].Duration || !bytes.Equal(f.Image.Pix, a.Frames[i].Image.Pix) {
+			t.Errorf("frame %d differs after the round trip", i)
+		}
+	}
+
+	if IsAnimatedWebP([]byte("RIFF\x04\x00\x00\x00WEBP")) {
+		t.Error("empty WebP reported as animated")
+	}
+}
+
+func TestDecodeWebPRejectsBadFrames(t *testing.T) {
+	a, err := FromGIF(disposalGIF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		patch func(anmf []byte)
+	}{
+		// Offsets are stored halved, so 1 moves a 4x4 frame to x=2
+		{"outside the canvas", func(anmf []byte) { anmf[0] = 1 }},
+		// The 4x4 bitstream no longer matches the declared 2x4 frame
+		{"size mismatch", func(anmf []byte) { anmf[6] = 1 }},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		if err := EncodeWebP(buf, a, 0); err != nil {
+			t.Fatal(err)
+		}
+		chunks, _ := readChunks(buf.Bytes())
+		tt.patch(chunks[len(chunks)-1].data)
+		if _, err := DecodeWebP(buf.Bytes()); !errors.Is(err, ErrInvalidAnimation) {
+			t.Errorf("%s: err = %v, want ErrInvalidAnimation", tt.name, err)
+		}
+	}
+}
+
+func TestGIFRoundTrip(t *testing.T) {
+	a, err := FromGIF(disposalGIF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := EncodeGIF(buf, a); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := CountGIFFrames(buf.Bytes()); err != nil || n != 4 {
+		t.Fatalf("CountGIFFrames = %d, %v", n, err)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := FromGIF(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.LoopCount != a.LoopCount || back.Duration() != a.Duration() {
+		t.Errorf("loop %d, duration %d, want %d and %d", back.LoopCount, back.Duration(), a.LoopCount, a.Duration())
+	}
+	// The palette's last slot holds transparency, so white comes back as
+	// the nearest Plan 9 gray; alpha must survive exactly
+	for i, f := range back.Frames {
+		for j, v := range f.Image.Pix {
+			want := a.Frames[i].Image.Pix[j]
+			if d := int(v) - int(want); d < -20 || d > 20 || (j%4 == 3 && d != 0) {
+				t.Errorf("frame %d byte %d = %d, want %d", i, j, v, want)
+			}
+		}
+	}
+}
+
+func TestLoopCounts(t *testing.T) {
+	// GIF counts repeats (-1 plays once), WebP counts plays (0 forever)
+	for _, tt := range []struct{ gif, webp int }{{0, 0}, {-1, 1}, {4, 5}} {
+		g := disposalGIF()
+		g.LoopCount = tt.gif
+		a, err := FromGIF(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.LoopCount != tt.webp || gifLoopCount(a.LoopCount) != tt.gif {
+			t.Errorf("GIF loop %d: WebP %d, back %d", tt.gif, a.LoopCount, gifLoopCount(a.LoopCount))
+		}
+	}
+}
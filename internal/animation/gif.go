@@ -0,0 +1,103 @@
+package animation
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// EncodeGIF writes an animated GIF, dithering each frame to the Plan 9
+// palette. Pixels that are mostly transparent use a transparent index.
+func EncodeGIF(w io.Writer, a *Animation) error {
+	pal := make(color.Palette, 0, 256)
+	pal = append(pal, palette.Plan9[:255]...)
+	pal = append(pal, color.Transparent)
+	transparent := uint8(len(pal) - 1)
+
+	g := &gif.GIF{
+		Config:    image.Config{Width: a.Width, Height: a.Height, ColorModel: pal},
+		LoopCount: gifLoopCount(a.LoopCount),
+	}
+	for _, f := range a.Frames {
+		b := f.Image.Bounds()
+		p := image.NewPaletted(b, pal[:255])
+		draw.FloydSteinberg.Draw(p, b, f.Image, b.Min)
+		p.Palette = pal
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if f.Image.RGBAAt(x, y).A < 128 {
+					p.SetColorIndex(x, y, transparent)
+				}
+			}
+		}
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, (f.Duration+5)/10)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// gifLoopCount converts a WebP play count to a GIF repeat count.
+func gifLoopCount(plays int) int {
+	switch plays {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	}
+	return plays - 1
+}
+
+// CountGIFFrames counts the image descriptors in a GIF by walking its block
+// structure, without decompressing any frame.
+func CountGIFFrames(data []byte) (int, error) {
+	if len(data) < 13 || string(data[0:3]) != "GIF" {
+		return 0, ErrInvalidAnimation
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1) // global color table
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos += 2
+		case 0x2C: // image descriptor, optional local color table, LZW code size
+			if pos+10 > len(data) {
+				return 0, ErrInvalidAnimation
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			frames++
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return 0, ErrInvalidAnimation
+		}
+
+		// Skip data sub-blocks up to the zero-length terminator
+		for {
+			if pos >= len(data) {
+				return 0, ErrInvalidAnimation
+			}
+			n := int(data[pos])
+			pos++
+			if n == 0 {
+				break
+			}
+			pos += n
+		}
+	}
+	// Truncated files without a trailer still decode; count what was seen
+	return frames, nil
+}
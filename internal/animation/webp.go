@@ -0,0 +1,273 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagAlpha     = 0x10
+
+	anmfFlagNoBlend = 0x02
+	anmfFlagDispose = 0x01
+)
+
+type chunk struct {
+	fourCC string
+	data   []byte
+}
+
+// Info describes an animated WebP without decoding its frames.
+type Info struct {
+	Width    int
+	Height   int
+	Frames   int
+	Duration int // milliseconds
+}
+
+// IsAnimatedWebP reports whether data is a WebP with the animation flag set.
+func IsAnimatedWebP(data []byte) bool {
+	chunks, err := readChunks(data)
+	if err != nil || len(chunks) == 0 || chunks[0].fourCC != "VP8X" || len(chunks[0].data) < 10 {
+		return false
+	}
+	return chunks[0].data[0]&vp8xFlagAnimation != 0
+}
+
+// Probe returns the canvas size, frame count and duration of an animated
+// WebP. ok is false for still images.
+func Probe(data []byte) (info Info, ok bool) {
+	if !IsAnimatedWebP(data) {
+		return info, false
+	}
+	chunks, _ := readChunks(data)
+	info.Width, info.Height = canvasSize(chunks[0].data)
+	for _, c := range chunks {
+		if c.fourCC == "ANMF" && len(c.data) >= 16 {
+			info.Frames++
+			info.Duration += int(uint24(c.data[12:]))
+		}
+	}
+	return info, true
+}
+
+// EncodeWebP writes an animated WebP. Quality 0 encodes lossless frames.
+func EncodeWebP(w io.Writer, a *Animation, quality int) error {
+	if len(a.Frames) == 0 {
+		return ErrInvalidAnimation
+	}
+
+	opts := &webp.Options{Lossless: true}
+	if quality > 0 {
+		opts = &webp.Options{Quality: float32(quality)}
+	}
+
+	body := new(bytes.Buffer)
+	hasAlpha := false
+	for _, f := range a.Frames {
+		buf := new(bytes.Buffer)
+		if err := webp.Encode(buf, f.Image, opts); err != nil {
+			return err
+		}
+		chunks, err := readChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		b := f.Image.Bounds()
+		header := make([]byte, 16)
+		putUint24(header[0:], 0) // X offset / 2
+		putUint24(header[3:], 0) // Y offset / 2
+		putUint24(header[6:], uint32(b.Dx()-1))
+		putUint24(header[9:], uint32(b.Dy()-1))
+		putUint24(header[12:], uint32(f.Duration))
+		header[15] = anmfFlagNoBlend
+
+		frame := bytes.NewBuffer(header)
+		for _, c := range chunks {
+			switch c.fourCC {
+			case "ALPH", "VP8 ", "VP8L":
+				writeChunk(frame, c.fourCC, c.data)
+			}
+			if c.fourCC == "ALPH" || c.fourCC == "VP8L" {
+				hasAlpha = true
+			}
+		}
+		writeChunk(body, "ANMF", frame.Bytes())
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = vp8xFlagAnimation
+	if hasAlpha {
+		vp8x[0] |= vp8xFlagAlpha
+	}
+	putUint24(vp8x[4:], uint32(a.Width-1))
+	putUint24(vp8x[7:], uint32(a.Height-1))
+
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(a.LoopCount))
+
+	out := new(bytes.Buffer)
+	writeChunk(out, "VP8X", vp8x)
+	writeChunk(out, "ANIM", anim)
+	out.Write(body.Bytes())
+
+	riff := make([]byte, 12)
+	copy(riff, "RIFF")
+	binary.LittleEndian.PutUint32(riff[4:], uint32(4+out.Len()))
+	copy(riff[8:], "WEBP")
+	if _, err := w.Write(riff); err != nil {
+		return err
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// DecodeWebP decodes every frame of an animated WebP onto a full canvas.
+func DecodeWebP(data []byte) (*Animation, error) {
+	if !IsAnimatedWebP(data) {
+		return nil, ErrInvalidAnimation
+	}
+	chunks, _ := readChunks(data)
+	w, h := canvasSize(chunks[0].data)
+	a := &Animation{Width: w, Height: h}
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for _, c := range chunks[1:] {
+		switch c.fourCC {
+		case "ANIM":
+			if len(c.data) >= 6 {
+				a.LoopCount = int(binary.LittleEndian.Uint16(c.data[4:]))
+			}
+		case "ANMF":
+			if len(c.data) < 16 {
+				return nil, ErrInvalidAnimation
+			}
+			x, y := int(uint24(c.data[0:]))*2, int(uint24(c.data[3:]))*2
+			fw, fh := int(uint24(c.data[6:]))+1, int(uint24(c.data[9:]))+1
+			duration := int(uint24(c.data[12:]))
+			flags := c.data[15]
+
+			rect := image.Rect(x, y, x+fw, y+fh)
+			if !rect.In(canvas.Bounds()) {
+				return nil, fmt.Errorf("%w: frame %v outside the %dx%d canvas", ErrInvalidAnimation, rect, w, h)
+			}
+			img, err := decodeFrame(c.data[16:], fw, fh)
+			if err != nil {
+				return nil, err
+			}
+
+			op := draw.Over
+			if flags&anmfFlagNoBlend != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+			a.Frames = append(a.Frames, Frame{Image: cloneRGBA(canvas), Duration: duration})
+
+			if flags&anmfFlagDispose != 0 {
+				draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+			}
+		}
+	}
+	if len(a.Frames) == 0 {
+		return nil, ErrInvalidAnimation
+	}
+	return a, nil
+}
+
+// decodeFrame wraps the bitstream chunks of one ANMF frame in a standalone
+// WebP container and decodes it. The bitstream must match the frame size
+// declared by its ANMF header, which the canvas limits were checked against.
+func decodeFrame(data []byte, w, h int) (image.Image, error) {
+	chunks, err := parseChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	for _, c := range chunks {
+		if c.fourCC == "ALPH" {
+			// Lossy frames with alpha need an extended header
+			vp8x := make([]byte, 10)
+			vp8x[0] = vp8xFlagAlpha
+			putUint24(vp8x[4:], uint32(w-1))
+			putUint24(vp8x[7:], uint32(h-1))
+			writeChunk(body, "VP8X", vp8x)
+			break
+		}
+	}
+	for _, c := range chunks {
+		writeChunk(body, c.fourCC, c.data)
+	}
+
+	file := new(bytes.Buffer)
+	file.WriteString("RIFF")
+	binary.Write(file, binary.LittleEndian, uint32(4+body.Len()))
+	file.WriteString("WEBP")
+	file.Write(body.Bytes())
+
+	cfg, err := webp.DecodeConfig(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: frame: %v", ErrInvalidAnimation, err)
+	}
+	if cfg.Width != w || cfg.Height != h {
+		return nil, fmt.Errorf("%w: %dx%d frame declared as %dx%d", ErrInvalidAnimation, cfg.Width, cfg.Height, w, h)
+	}
+
+	img, err := webp.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: frame: %v", ErrInvalidAnimation, err)
+	}
+	return img, nil
+}
+
+func readChunks(data []byte) ([]chunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrInvalidAnimation
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := min(8+size, len(data))
+	return parseChunks(data[12:end])
+}
+
+func parseChunks(data []byte) ([]chunk, error) {
+	var chunks []chunk
+	for len(data) >= 8 {
+		fourCC := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || 8+size > len(data) {
+			return nil, ErrInvalidAnimation
+		}
+		chunks = append(chunks, chunk{fourCC: fourCC, data: data[8 : 8+size]})
+		data = data[min(8+size+size%2, len(data)):]
+	}
+	return chunks, nil
+}
+
+func writeChunk(w *bytes.Buffer, fourCC string, data []byte) {
+	w.WriteString(fourCC)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func canvasSize(vp8x []byte) (int, int) {
+	return int(uint24(vp8x[4:])) + 1, int(uint24(vp8x[7:])) + 1
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
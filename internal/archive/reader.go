@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// Entry is a single regular file read from an archive.
+type Entry struct {
+	Name string
+	Data []byte
+	Err  error
+}
+
+// IsArchive reports whether the filename looks like a supported archive.
+func IsArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".zip") ||
+		strings.HasSuffix(name, ".tar") ||
+		strings.HasSuffix(name, ".tar.gz") ||
+		strings.HasSuffix(name, ".tgz")
+}
+
+var (
+	ErrTooManyFiles = errors.New("too many files")
+	ErrTooLarge     = errors.New("uncompressed files exceed the size limit")
+)
+
+// Budget bounds what one request may extract, across all of its archives
+// and plain files. Extract decrements it as entries are read.
+type Budget struct {
+	Files       int   // files still allowed
+	Bytes       int64 // uncompressed bytes still allowed
+	MaxFileSize int64 // per file
+}
+
+// Take charges one file of size bytes to the budget.
+func (b *Budget) Take(size int64) error {
+	if b.Files <= 0 {
+		return ErrTooManyFiles
+	}
+	if size > b.Bytes {
+		return ErrTooLarge
+	}
+	b.Files--
+	b.Bytes -= size
+	return nil
+}
+
+// Extract reads every regular file from a zip or tar archive. Entries larger
+// than the budget's MaxFileSize are returned with an error instead of their
+// data. Extraction stops with ErrTooManyFiles or ErrTooLarge as soon as the
+// budget runs out, so archives are never fully expanded beyond it.
+func Extract(filename string, data []byte, budget *Budget) ([]Entry, error) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(data, budget)
+	case strings.HasSuffix(name, ".tar"):
+		return extractTar(bytes.NewReader(data), budget)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return extractTar(gz, budget)
+	}
+	return nil, ErrUnsupportedArchive
+}
+
+func extractZip(data []byte, budget *Budget) ([]Entry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || skipEntry(f.Name) {
+			continue
+		}
+
+		entry := Entry{Name: f.Name}
+		if int64(f.UncompressedSize64) > budget.MaxFileSize {
+			if err := budget.Take(0); err != nil {
+				return nil, err
+			}
+			entry.Err = fmt.Errorf("file exceeds %d bytes", budget.MaxFileSize)
+			entries = append(entries, entry)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			if err := budget.Take(0); err != nil {
+				return nil, err
+			}
+			entry.Err = err
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Data, entry.Err = readEntry(rc, budget)
+		rc.Close()
+		if errors.Is(entry.Err, ErrTooManyFiles) || errors.Is(entry.Err, ErrTooLarge) {
+			return nil, entry.Err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func extractTar(r io.Reader, budget *Budget) ([]Entry, error) {
+	tr := tar.NewReader(r)
+
+	var entries []Entry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || skipEntry(hdr.Name) {
+			continue
+		}
+
+		entry := Entry{Name: hdr.Name}
+		if hdr.Size > budget.MaxFileSize {
+			if err := budget.Take(0); err != nil {
+				return nil, err
+			}
+			entry.Err = fmt.Errorf("file exceeds %d bytes", budget.MaxFileSize)
+		} else {
+			entry.Data, entry.Err = readEntry(tr, budget)
+			if errors.Is(entry.Err, ErrTooManyFiles) || errors.Is(entry.Err, ErrTooLarge) {
+				return nil, entry.Err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readEntry reads one entry, never more than the per-file limit or the
+// remaining budget, whichever is smaller; headers may understate sizes.
+func readEntry(r io.Reader, budget *Budget) ([]byte, error) {
+	if budget.Files <= 0 {
+		return nil, ErrTooManyFiles
+	}
+	limit := min(budget.MaxFileSize, budget.Bytes)
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > budget.MaxFileSize {
+		if err := budget.Take(0); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("file exceeds %d bytes", budget.MaxFileSize)
+	}
+	if err := budget.Take(int64(len(data))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// skipEntry filters out metadata files that archivers commonly add.
+func skipEntry(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/")
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+func zipOf(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	files := []testFile{
+		{"a.png", []byte("aaaa")},
+		{"dir/b.png", []byte("bb")},
+		{".DS_Store", []byte("x")},
+		{"__MACOSX/dir/._b.png", []byte("x")},
+		{"big.png", make([]byte, 64)},
+	}
+	for name, data := range map[string][]byte{"in.zip": zipOf(t, files...), "in.tar": tarOf(t, files...)} {
+		budget := &Budget{Files: 10, Bytes: 1000, MaxFileSize: 16}
+		entries, err := Extract(name, data, budget)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("%s: got %d entries, want 3: %+v", name, len(entries), entries)
+		}
+		if entries[0].Name != "a.png" || string(entries[0].Data) != "aaaa" || entries[1].Name != "dir/b.png" {
+			t.Errorf("%s: entries = %+v", name, entries)
+		}
+		if entries[2].Err == nil || entries[2].Data != nil {
+			t.Errorf("%s: oversized entry read: %+v", name, entries[2])
+		}
+		// Oversized files count against the file cap but not the byte cap
+		if budget.Files != 7 || budget.Bytes != 994 {
+			t.Errorf("%s: budget = %+v, want 7 files and 994 bytes left", name, budget)
+		}
+	}
+}
+
+func TestExtractBudget(t *testing.T) {
+	files := []testFile{{"a.png", make([]byte, 10)}, {"b.png", make([]byte, 10)}, {"c.png", make([]byte, 10)}}
+	tests := []struct {
+		name   string
+		budget Budget
+		want   error
+	}{
+		{"files", Budget{Files: 2, Bytes: 100, MaxFileSize: 100}, ErrTooManyFiles},
+		{"bytes", Budget{Files: 10, Bytes: 25, MaxFileSize: 100}, ErrTooLarge},
+		{"fits", Budget{Files: 3, Bytes: 30, MaxFileSize: 100}, nil},
+	}
+	for _, tt := range tests {
+		for name, data := range map[string][]byte{"in.zip": zipOf(t, files...), "in.tar": tarOf(t, files...)} {
+			budget := tt.budget
+			_, err := Extract(name, data, &budget)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s %s: err = %v, want %v", tt.name, name, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	if _, err := Extract("in.rar", nil, &Budget{}); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("err = %v", err)
+	}
+	if IsArchive("photo.png") || !IsArchive("Photos.TAR.GZ") {
+		t.Error("IsArchive misclassifies names")
+	}
+}
@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"time"
+)
+
+// ManifestName is the archive entry describing the exported files.
+const ManifestName = "manifest.json"
+
+// Writer streams files into an archive without buffering it in memory.
+type Writer interface {
+	WriteFile(name string, data []byte, modTime time.Time) error
+	Close() error
+}
+
+// NewWriter returns a streaming writer for the given format ("zip" or "tar").
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case "zip":
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	case "tar":
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	}
+	return nil, ErrUnsupportedArchive
+}
+
+// ContentType returns the MIME type for an archive format.
+func ContentType(format string) string {
+	if format == "tar" {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) WriteFile(name string, data []byte, modTime time.Time) error {
+	// WebP is already compressed, so entries are stored rather than deflated
+	method := zip.Store
+	if name == ManifestName {
+		method = zip.Deflate
+	}
+	f, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+func (w *tarWriter) WriteFile(name string, data []byte, modTime time.Time) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	return w.tw.Close()
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []testFile{
+		{"images/a.webp", []byte("first image")},
+		{"images/b.webp", []byte("second image")},
+		{ManifestName, []byte(`{"count":2}`)},
+	}
+	for _, format := range []string{"zip", "tar"} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if err := w.WriteFile(f.name, f.data, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := Extract("export."+format, buf.Bytes(), &Budget{Files: 10, Bytes: 1 << 20, MaxFileSize: 1 << 20})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(entries) != len(files) {
+			t.Fatalf("%s: got %d entries, want %d", format, len(entries), len(files))
+		}
+		for i, f := range files {
+			if entries[i].Name != f.name || !bytes.Equal(entries[i].Data, f.data) {
+				t.Errorf("%s: entry %d = %s %q, want %s %q", format, i, entries[i].Name, entries[i].Data, f.name, f.data)
+			}
+		}
+	}
+
+	if _, err := NewWriter(new(bytes.Buffer), "rar"); err != ErrUnsupportedArchive {
+		t.Errorf("rar: err = %v", err)
+	}
+}
@@ -2,19 +2,30 @@ package cache
 
 import (
 	"container/list"
+	"strings"
 	"sync"
 )
 
 type Cache interface {
 	Get(key string) ([]byte, bool)
 	Set(key string, value []byte)
+	SetIfGeneration(key string, value []byte, generation uint64) bool
+	Generation() uint64
+	DeletePrefix(prefix string) int
+	DeleteFunc(match func(key string) bool) int
 }
 
 type MemoryCache struct {
 	capacity int
-	cache    map[string]*list.Element
-	list     *list.List
-	mu       sync.Mutex
+	maxBytes int64 // total size of values, 0 for no limit
+	maxEntry int64 // larger values are not cached, 0 for no limit
+	size     int64
+	// generation counts deletions, so callers can detect an invalidation
+	// that happened while they were computing a value
+	generation uint64
+	cache      map[string]*list.Element
+	list       *list.List
+	mu         sync.Mutex
 }
 
 type cacheItem struct {
@@ -23,8 +34,16 @@ type cacheItem struct {
 }
 
 func NewMemoryCache(capacity int) *MemoryCache {
+	return NewBoundedMemoryCache(capacity, 0, 0)
+}
+
+// NewBoundedMemoryCache also limits the total size of the cached values to
+// maxBytes and skips values larger than maxEntry. Zero disables either limit.
+func NewBoundedMemoryCache(capacity int, maxBytes, maxEntry int64) *MemoryCache {
 	return &MemoryCache{
 		capacity: capacity,
+		maxBytes: maxBytes,
+		maxEntry: maxEntry,
 		cache:    make(map[string]*list.Element),
 		list:     list.New(),
 	}
@@ -44,23 +63,74 @@ func (c *MemoryCache) Get(key string) ([]byte, bool) {
 func (c *MemoryCache) Set(key string, value []byte) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.set(key, value)
+}
 
+// SetIfGeneration stores the value only if nothing was deleted since
+// Generation returned generation, and reports whether it did.
+func (c *MemoryCache) SetIfGeneration(key string, value []byte, generation uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return false
+	}
+	c.set(key, value)
+	return true
+}
+
+// Generation returns the current deletion count, see SetIfGeneration.
+func (c *MemoryCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *MemoryCache) set(key string, value []byte) {
 	if elem, exists := c.cache[key]; exists {
-		c.list.MoveToFront(elem)
-		elem.Value.(*cacheItem).value = value
+		c.remove(elem)
+	}
+	size := int64(len(value))
+	if c.maxEntry > 0 && size > c.maxEntry || c.maxBytes > 0 && size > c.maxBytes {
 		return
 	}
 
-	if c.list.Len() >= c.capacity {
-		// Remove the least recently used item
-		last := c.list.Back()
-		if last != nil {
-			delete(c.cache, last.Value.(*cacheItem).key)
-			c.list.Remove(last)
-		}
+	// Remove the least recently used items until the new one fits
+	for c.list.Len() > 0 && (c.list.Len() >= c.capacity || c.maxBytes > 0 && c.size+size > c.maxBytes) {
+		c.remove(c.list.Back())
 	}
 
 	item := &cacheItem{key: key, value: value}
 	elem := c.list.PushFront(item)
 	c.cache[key] = elem
+	c.size += size
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	item := elem.Value.(*cacheItem)
+	delete(c.cache, item.key)
+	c.list.Remove(elem)
+	c.size -= int64(len(item.value))
+}
+
+// DeletePrefix removes every entry whose key starts with prefix and returns
+// how many were removed.
+func (c *MemoryCache) DeletePrefix(prefix string) int {
+	return c.DeleteFunc(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// DeleteFunc removes every entry whose key matches and returns how many were
+// removed.
+func (c *MemoryCache) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	removed := 0
+	for key, elem := range c.cache {
+		if match(key) {
+			c.remove(elem)
+			removed++
+		}
+	}
+	return removed
 }
@@ -0,0 +1,63 @@
+package cache
+
+import "testing"
+
+func TestMemoryCacheByteLimit(t *testing.T) {
+	c := NewBoundedMemoryCache(10, 100, 60)
+	c.Set("a", make([]byte, 40))
+	c.Set("b", make([]byte, 40))
+	c.Get("a")
+
+	// b is the least recently used and makes room for c
+	c.Set("c", make([]byte, 40))
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+
+	// Entries above the per-entry limit are not cached and drop the old value
+	c.Set("a", make([]byte, 61))
+	if _, ok := c.Get("a"); ok {
+		t.Error("oversized entry cached")
+	}
+	if c.size != 40 {
+		t.Errorf("size = %d, want 40", c.size)
+	}
+
+	c.Set("c", make([]byte, 10))
+	if c.DeletePrefix("c") != 1 || c.size != 0 || c.list.Len() != 0 {
+		t.Errorf("after delete: size = %d, entries = %d", c.size, c.list.Len())
+	}
+}
+
+func TestMemoryCacheCapacity(t *testing.T) {
+	c := NewMemoryCache(2)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3"))
+	if _, ok := c.Get("a"); ok {
+		t.Error("a not evicted")
+	}
+	if v, ok := c.Get("c"); !ok || string(v) != "3" {
+		t.Errorf("c = %q, %v", v, ok)
+	}
+}
+
+func TestSetIfGeneration(t *testing.T) {
+	c := NewMemoryCache(10)
+	generation := c.Generation()
+	c.DeletePrefix("x/")
+	if c.SetIfGeneration("x/1", []byte("stale"), generation) {
+		t.Error("value stored after an invalidation")
+	}
+	if _, ok := c.Get("x/1"); ok {
+		t.Error("stale value cached")
+	}
+	if !c.SetIfGeneration("x/1", []byte("fresh"), c.Generation()) {
+		t.Error("value not stored")
+	}
+}
@@ -0,0 +1,498 @@
+// Package exif extracts, parses and rewrites the EXIF and XMP metadata
+// embedded in JPEG, PNG and WebP files.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrNoExif = errors.New("no EXIF data")
+
+const (
+	tagOrientation    = 0x0112
+	tagMake           = 0x010F
+	tagModel          = 0x0110
+	tagDateTime       = 0x0132
+	tagExifIFD        = 0x8769
+	tagGPSIFD         = 0x8825
+	tagExposureTime   = 0x829A
+	tagFNumber        = 0x829D
+	tagISO            = 0x8827
+	tagDateTimeOrig   = 0x9003
+	tagFocalLength    = 0x920A
+	tagLensModel      = 0xA434
+	tagGPSLatRef      = 0x0001
+	tagGPSLat         = 0x0002
+	tagGPSLonRef      = 0x0003
+	tagGPSLon         = 0x0004
+	typeASCII         = 2
+	typeShort         = 3
+	typeLong          = 4
+	typeRational      = 5
+	exifDateLayout    = "2006:01:02 15:04:05"
+	maxEntriesPerIFD  = 1000
+	jpegExifSignature = "Exif\x00\x00"
+	jpegXMPSignature  = "http://ns.adobe.com/xap/1.0/\x00"
+)
+
+// Data holds the EXIF fields we care about.
+type Data struct {
+	Orientation  int
+	Make         string
+	Model        string
+	LensModel    string
+	TakenAt      time.Time
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+	HasGPS       bool
+	Latitude     float64
+	Longitude    float64
+}
+
+// Extract returns the raw TIFF-structured EXIF payload of a JPEG, PNG or
+// WebP file.
+func Extract(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegSegment(data, jpegExifSignature)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngChunk(data, "eXIf")
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		raw, err := riffChunk(data, "EXIF")
+		if err != nil {
+			return nil, err
+		}
+		// Some writers keep the JPEG "Exif\0\0" prefix inside the chunk
+		return bytes.TrimPrefix(raw, []byte(jpegExifSignature)), nil
+	}
+	return nil, ErrNoExif
+}
+
+// ExtractXMP returns the XMP packet of a JPEG, PNG or WebP file.
+func ExtractXMP(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegSegment(data, jpegXMPSignature)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		const keyword = "XML:com.adobe.xmp\x00"
+		for _, raw := range pngChunks(data, "iTXt") {
+			if !bytes.HasPrefix(raw, []byte(keyword)) {
+				continue
+			}
+			// compression flag, compression method, language\0, translated keyword\0, text
+			rest := raw[len(keyword):]
+			if len(rest) < 2 || rest[0] != 0 {
+				return nil, ErrNoExif
+			}
+			parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+			if len(parts) < 3 {
+				return nil, ErrNoExif
+			}
+			return parts[2], nil
+		}
+		return nil, ErrNoExif
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return riffChunk(data, "XMP ")
+	}
+	return nil, ErrNoExif
+}
+
+// Parse decodes the fields of interest from a raw EXIF payload.
+func Parse(raw []byte) (*Data, error) {
+	t, err := newTIFF(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Data{Orientation: 1}
+	ifd0, err := t.readIFD(t.firstIFD)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := ifd0[tagOrientation]; ok {
+		if v := int(t.uint(e)); v >= 1 && v <= 8 {
+			d.Orientation = v
+		}
+	}
+	d.Make = t.ascii(ifd0[tagMake])
+	d.Model = t.ascii(ifd0[tagModel])
+	if ts := t.ascii(ifd0[tagDateTime]); ts != "" {
+		d.TakenAt, _ = time.Parse(exifDateLayout, ts)
+	}
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.readIFD(t.uint(e)); err == nil {
+			if ts := t.ascii(sub[tagDateTimeOrig]); ts != "" {
+				if taken, err := time.Parse(exifDateLayout, ts); err == nil {
+					d.TakenAt = taken
+				}
+			}
+			if e, ok := sub[tagExposureTime]; ok {
+				if num, den := t.rational(e, 0); den != 0 {
+					if num == 1 || num >= den {
+						d.ExposureTime = formatExposure(num, den)
+					} else {
+						d.ExposureTime = fmt.Sprintf("1/%d", uint32(math.Round(float64(den)/float64(num))))
+					}
+				}
+			}
+			if e, ok := sub[tagFNumber]; ok {
+				if num, den := t.rational(e, 0); den != 0 {
+					d.FNumber = float64(num) / float64(den)
+				}
+			}
+			if e, ok := sub[tagISO]; ok {
+				d.ISO = int(t.uint(e))
+			}
+			if e, ok := sub[tagFocalLength]; ok {
+				if num, den := t.rational(e, 0); den != 0 {
+					d.FocalLength = float64(num) / float64(den)
+				}
+			}
+			d.LensModel = t.ascii(sub[tagLensModel])
+		}
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.readIFD(t.uint(e)); err == nil {
+			lat, latOK := t.degrees(gps[tagGPSLat])
+			lon, lonOK := t.degrees(gps[tagGPSLon])
+			if latOK && lonOK {
+				if t.ascii(gps[tagGPSLatRef]) == "S" {
+					lat = -lat
+				}
+				if t.ascii(gps[tagGPSLonRef]) == "W" {
+					lon = -lon
+				}
+				d.HasGPS = true
+				d.Latitude = lat
+				d.Longitude = lon
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// StripGPS returns a copy of raw with the GPS IFD emptied and its values
+// zeroed, so no location data survives in the payload.
+func StripGPS(raw []byte) ([]byte, error) {
+	out := append([]byte(nil), raw...)
+	t, err := newTIFF(out)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := t.readIFD(t.firstIFD)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := ifd0[tagGPSIFD]
+	if !ok {
+		return out, nil
+	}
+
+	// Walk the raw entries rather than the parsed map, which keeps only the
+	// last of any duplicated tags
+	offset := t.uint(e)
+	if _, err := t.readIFD(offset); err != nil {
+		return nil, err
+	}
+	n := uint32(t.order.Uint16(out[offset:]))
+	for i := uint32(0); i < n; i++ {
+		entry := t.entryAt(offset + 2 + i*12)
+		if size := entry.size(); size > 4 {
+			start := int(entry.value)
+			if start+size <= len(out) {
+				clear(out[start : start+size])
+			}
+		}
+	}
+	clear(out[offset+2 : offset+2+n*12])
+	t.order.PutUint16(out[offset:], 0)
+	return out, nil
+}
+
+// ResetOrientation returns a copy of raw with the orientation tag set to 1,
+// for use after the rotation has been applied to the pixels.
+func ResetOrientation(raw []byte) ([]byte, error) {
+	out := append([]byte(nil), raw...)
+	t, err := newTIFF(out)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := t.readIFD(t.firstIFD)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := ifd0[tagOrientation]; ok && e.typ == typeShort {
+		t.order.PutUint16(out[e.valueOffset:], 1)
+	}
+	return out, nil
+}
+
+// StripXMPLocation removes exif:GPS* properties from an XMP packet.
+func StripXMPLocation(xmp []byte) []byte {
+	s := string(xmp)
+	for {
+		i := strings.Index(s, "exif:GPS")
+		if i < 0 {
+			break
+		}
+		// Element form: <exif:GPSLatitude>...</exif:GPSLatitude>
+		if i > 0 && s[i-1] == '<' {
+			end := strings.IndexAny(s[i:], " >/")
+			if end < 0 {
+				break
+			}
+			name := s[i : i+end]
+			closing := "</" + name + ">"
+			j := strings.Index(s[i:], closing)
+			if j < 0 {
+				// Self-closing element
+				j = strings.Index(s[i:], "/>")
+				if j < 0 {
+					break
+				}
+				s = s[:i-1] + s[i+j+2:]
+				continue
+			}
+			s = s[:i-1] + s[i+j+len(closing):]
+			continue
+		}
+		// Attribute form: exif:GPSLatitude="..."
+		eq := strings.Index(s[i:], "=")
+		if eq < 0 || i+eq+1 >= len(s) {
+			break
+		}
+		quote := s[i+eq+1]
+		end := strings.IndexByte(s[i+eq+2:], quote)
+		if end < 0 {
+			break
+		}
+		s = s[:i] + s[i+eq+2+end+1:]
+	}
+	return []byte(s)
+}
+
+func formatExposure(num, den uint32) string {
+	if num%den == 0 {
+		return fmt.Sprintf("%d", num/den)
+	}
+	if num == 1 {
+		return fmt.Sprintf("1/%d", den)
+	}
+	return fmt.Sprintf("%.1f", float64(num)/float64(den))
+}
+
+type tiff struct {
+	data     []byte
+	order    binary.ByteOrder
+	firstIFD uint32
+}
+
+type entry struct {
+	typ         uint16
+	count       uint32
+	value       uint32 // inline value or offset, depending on size
+	valueOffset uint32 // position of the value field itself
+}
+
+func (e entry) size() int {
+	switch e.typ {
+	case typeShort:
+		return int(e.count) * 2
+	case typeLong, 9:
+		return int(e.count) * 4
+	case typeRational, 10:
+		return int(e.count) * 8
+	}
+	return int(e.count)
+}
+
+func newTIFF(raw []byte) (*tiff, error) {
+	if len(raw) < 8 {
+		return nil, ErrNoExif
+	}
+	t := &tiff{data: raw}
+	switch string(raw[0:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+	if t.order.Uint16(raw[2:4]) != 42 {
+		return nil, fmt.Errorf("invalid TIFF header")
+	}
+	t.firstIFD = t.order.Uint32(raw[4:8])
+	return t, nil
+}
+
+func (t *tiff) readIFD(offset uint32) (map[uint16]entry, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	if n > maxEntriesPerIFD || int(offset)+2+n*12 > len(t.data) {
+		return nil, fmt.Errorf("IFD entries out of range")
+	}
+
+	entries := make(map[uint16]entry, n)
+	for i := 0; i < n; i++ {
+		p := offset + 2 + uint32(i)*12
+		entries[t.order.Uint16(t.data[p:])] = t.entryAt(p)
+	}
+	return entries, nil
+}
+
+// entryAt decodes the type, count and value of the IFD entry at p.
+func (t *tiff) entryAt(p uint32) entry {
+	return entry{
+		typ:         t.order.Uint16(t.data[p+2:]),
+		count:       t.order.Uint32(t.data[p+4:]),
+		value:       t.order.Uint32(t.data[p+8:]),
+		valueOffset: p + 8,
+	}
+}
+
+// bytesOf returns the value bytes of an entry, inline or at its offset.
+func (t *tiff) bytesOf(e entry) []byte {
+	size := e.size()
+	if size <= 4 {
+		return t.data[e.valueOffset : e.valueOffset+uint32(size)]
+	}
+	if int(e.value)+size > len(t.data) || size < 0 {
+		return nil
+	}
+	return t.data[e.value : e.value+uint32(size)]
+}
+
+func (t *tiff) uint(e entry) uint32 {
+	b := t.bytesOf(e)
+	switch e.typ {
+	case typeShort:
+		if len(b) >= 2 {
+			return uint32(t.order.Uint16(b))
+		}
+	case typeLong:
+		if len(b) >= 4 {
+			return t.order.Uint32(b)
+		}
+	}
+	return 0
+}
+
+func (t *tiff) ascii(e entry) string {
+	if e.typ != typeASCII {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(t.bytesOf(e)), "\x00"))
+}
+
+func (t *tiff) rational(e entry, i int) (uint32, uint32) {
+	if e.typ != typeRational || uint32(i) >= e.count {
+		return 0, 0
+	}
+	b := t.bytesOf(e)
+	if len(b) < (i+1)*8 {
+		return 0, 0
+	}
+	return t.order.Uint32(b[i*8:]), t.order.Uint32(b[i*8+4:])
+}
+
+// degrees converts a GPS degrees/minutes/seconds triple to decimal degrees.
+func (t *tiff) degrees(e entry) (float64, bool) {
+	if e.count < 3 {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num, den := t.rational(e, i)
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+func jpegSegment(data []byte, signature string) ([]byte, error) {
+	p := 2
+	for p+4 <= len(data) {
+		if data[p] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := data[p+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			p += 2
+			continue
+		}
+		// Start of scan: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if length < 2 || p+2+length > len(data) {
+			break
+		}
+		payload := data[p+4 : p+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte(signature)) {
+			return payload[len(signature):], nil
+		}
+		p += 2 + length
+	}
+	return nil, ErrNoExif
+}
+
+func pngChunk(data []byte, name string) ([]byte, error) {
+	chunks := pngChunks(data, name)
+	if len(chunks) == 0 {
+		return nil, ErrNoExif
+	}
+	return chunks[0], nil
+}
+
+// pngChunks returns the payloads of all chunks of the given type that appear
+// before the image data.
+func pngChunks(data []byte, name string) [][]byte {
+	var chunks [][]byte
+	p := 8
+	for p+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		typ := string(data[p+4 : p+8])
+		if length < 0 || p+12+length > len(data) || typ == "IDAT" {
+			break
+		}
+		if typ == name {
+			chunks = append(chunks, data[p+8:p+8+length])
+		}
+		p += 12 + length
+	}
+	return chunks
+}
+
+func riffChunk(data []byte, name string) ([]byte, error) {
+	p := 12
+	for p+8 <= len(data) {
+		typ := string(data[p : p+4])
+		length := int(binary.LittleEndian.Uint32(data[p+4:]))
+		if length < 0 || p+8+length > len(data) {
+			break
+		}
+		if typ == name {
+			return data[p+8 : p+8+length], nil
+		}
+		p += 8 + length + length%2
+	}
+	return nil, ErrNoExif
+}
@@ -0,0 +1,99 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte // stored inline when it fits in 4 bytes
+}
+
+// buildTIFF writes a little-endian TIFF with IFD0 pointing at a GPS IFD
+// holding the given entries, in order and duplicates included.
+func buildTIFF(gps []ifdEntry) []byte {
+	le := binary.LittleEndian
+	const gpsOffset = 8 + 2 + 12 + 4
+	buf := []byte("II*\x00\x08\x00\x00\x00")
+	buf = le.AppendUint16(buf, 1)
+	buf = le.AppendUint16(buf, tagGPSIFD)
+	buf = le.AppendUint16(buf, typeLong)
+	buf = le.AppendUint32(buf, 1)
+	buf = le.AppendUint32(buf, gpsOffset)
+	buf = le.AppendUint32(buf, 0)
+
+	dataOffset := uint32(gpsOffset + 2 + len(gps)*12 + 4)
+	var values []byte
+	buf = le.AppendUint16(buf, uint16(len(gps)))
+	for _, e := range gps {
+		buf = le.AppendUint16(buf, e.tag)
+		buf = le.AppendUint16(buf, e.typ)
+		buf = le.AppendUint32(buf, e.count)
+		if len(e.value) <= 4 {
+			buf = append(buf, e.value...)
+			buf = append(buf, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		buf = le.AppendUint32(buf, dataOffset+uint32(len(values)))
+		values = append(values, e.value...)
+	}
+	buf = le.AppendUint32(buf, 0)
+	return append(buf, values...)
+}
+
+func rationals(vals ...uint32) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = binary.LittleEndian.AppendUint32(b, v)
+		b = binary.LittleEndian.AppendUint32(b, 1)
+	}
+	return b
+}
+
+func TestStripGPS(t *testing.T) {
+	raw := buildTIFF([]ifdEntry{
+		{tagGPSLatRef, typeASCII, 2, []byte("N\x00")},
+		{tagGPSLat, typeRational, 3, rationals(0x4c41541, 0x4c41542, 0x4c41543)},
+		{tagGPSLat, typeRational, 3, rationals(0x4c41544, 0x4c41545, 0x4c41546)},
+		{tagGPSLonRef, typeASCII, 2, []byte("E\x00")},
+		{tagGPSLon, typeRational, 3, rationals(0x4c4f4e1, 0x4c4f4e2, 0x4c4f4e3)},
+	})
+	if d, err := Parse(raw); err != nil || !d.HasGPS {
+		t.Fatalf("Parse = %+v, %v, want a location", d, err)
+	}
+
+	out, err := StripGPS(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(raw) {
+		t.Fatalf("length changed from %d to %d", len(raw), len(out))
+	}
+	if d, err := Parse(out); err != nil || d.HasGPS {
+		t.Errorf("Parse after StripGPS = %+v, %v", d, err)
+	}
+
+	// Nothing after the IFD0 pointer survives: entries, both duplicated
+	// latitudes and the longitude that followed them
+	const gpsOffset, ifdEnd = 26, 26 + 2 + 5*12
+	if ifd := out[gpsOffset:ifdEnd]; !bytes.Equal(ifd, make([]byte, len(ifd))) {
+		t.Errorf("GPS entries not cleared: % x", ifd)
+	}
+	if values := out[ifdEnd+4:]; !bytes.Equal(values, make([]byte, len(values))) {
+		t.Errorf("GPS values not cleared: % x", values)
+	}
+	if !bytes.Equal(out[:gpsOffset], raw[:gpsOffset]) {
+		t.Error("IFD0 was modified")
+	}
+}
+
+func TestStripGPSWithoutGPS(t *testing.T) {
+	raw := []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	out, err := StripGPS(raw)
+	if err != nil || !bytes.Equal(out, raw) {
+		t.Errorf("StripGPS = % x, %v", out, err)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type formFile struct {
+	name string
+	data []byte
+}
+
+type batchResponse struct {
+	Results []struct {
+		Name  string `json:"name"`
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+func uploadBatch(t *testing.T, h *ImageHandler, files ...formFile) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for _, f := range files {
+		part, err := w.CreateFormFile("files", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(f.data)
+	}
+	w.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/images/batch", body)
+	c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	h.CreateImages(c)
+	return rec
+}
+
+func decodeBatch(t *testing.T, rec *httptest.ResponseRecorder) batchResponse {
+	t.Helper()
+	var resp batchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	return resp
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T, files ...formFile) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCreateImagesDuplicateIDs(t *testing.T) {
+	h := newTestHandler(t)
+	pic := testPNG(t)
+	rec := uploadBatch(t, h,
+		formFile{"logo.png", pic},
+		formFile{"photos.zip", testZip(t, formFile{"a/logo.png", pic}, formFile{"b/icon.png", pic})},
+	)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body)
+	}
+	resp := decodeBatch(t, rec)
+	if resp.Succeeded != 2 || resp.Failed != 1 {
+		t.Fatalf("response = %+v, want 2 succeeded and 1 failed", resp)
+	}
+	if r := resp.Results[1]; r.Name != "a/logo.png" || r.Error == "" {
+		t.Errorf("second logo = %+v, want a duplicate ID error", r)
+	}
+	if r := resp.Results[2]; r.ID != "icon" {
+		t.Errorf("icon = %+v", r)
+	}
+}
+
+func TestCreateImagesFileCap(t *testing.T) {
+	t.Setenv("BATCH_MAX_FILES", "2")
+	h := newTestHandler(t)
+	pic := testPNG(t)
+
+	rec := uploadBatch(t, h, formFile{"a.png", pic}, formFile{"b.png", pic}, formFile{"c.png", pic})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("plain files: status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+
+	// Archive entries count towards the same cap
+	archive := testZip(t, formFile{"b.png", pic}, formFile{"c.png", pic})
+	rec = uploadBatch(t, h, formFile{"a.png", pic}, formFile{"more.zip", archive})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("archive: status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateImagesUnpackedCap(t *testing.T) {
+	t.Setenv("BATCH_MAX_UNPACKED_SIZE_MB", "1")
+	h := newTestHandler(t)
+
+	// Zeros compress to a few KB, well under the 1 MB request limit
+	archive := testZip(t, formFile{"a.png", make([]byte, 600<<10)}, formFile{"b.png", make([]byte, 600<<10)})
+	rec := uploadBatch(t, h, formFile{"bomb.zip", archive})
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateImagesPathTraversal(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "data")
+	h := newTestHandlerIn(t, dir)
+	pic := testPNG(t)
+
+	rec := uploadBatch(t, h, formFile{"evil.zip", testZip(t,
+		formFile{"../../escape.png", pic},
+		formFile{"/abs/rooted.png", pic},
+	)})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	resp := decodeBatch(t, rec)
+	if resp.Results[0].ID != "escape" || resp.Results[1].ID != "rooted" {
+		t.Fatalf("results = %+v, want IDs from the base names", resp.Results)
+	}
+
+	// Nothing is written outside the storage directory
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "data" {
+		t.Errorf("files outside the storage directory: %v", entries)
+	}
+	for _, id := range []string{"escape", "rooted"} {
+		if _, err := h.imageService.GetImage(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+}
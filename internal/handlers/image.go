@@ -2,88 +2,415 @@ package handlers
 
 import (
 	"bytes"
-	"image"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/chai2010/webp"
 	"github.com/gin-gonic/gin"
-	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/archive"
+	"github.com/kartex/imageprovider/internal/index"
+	"github.com/kartex/imageprovider/internal/middleware"
+	"github.com/kartex/imageprovider/internal/pool"
 	"github.com/kartex/imageprovider/internal/services"
+	"github.com/kartex/imageprovider/internal/transform"
+	"github.com/kartex/imageprovider/pkg/urlsign"
+)
+
+const (
+	defaultBatchMaxFiles    = 100
+	defaultBatchMaxFileMB   = 32
+	defaultMaxUploadMB      = 32
+	defaultBatchMaxUploadMB = 512
+	defaultBatchMaxUnpackMB = 1024
+	defaultSrcsetTTL        = 24 * time.Hour
+	defaultSrcsetMaxTTL     = 30 * 24 * time.Hour
 )
 
 type ImageHandler struct {
-	imageService *services.ImageService
+	imageService     *services.ImageService
+	batchMaxFiles    int
+	batchMaxFileSize int64
+	maxUploadSize    int64
+	batchMaxUpload   int64
+	batchMaxUnpacked int64
+	presetsOnly      bool
+	publicOverlay    *transform.Pipeline
+	signingKey       *urlsign.Key
+	srcsetTTL        time.Duration
+	srcsetMaxTTL     time.Duration
+	baseURL          string
+	keyPolicies      map[string]string // API key name -> upload policy
 }
 
 func NewImageHandler(imageService *services.ImageService) *ImageHandler {
+	maxFiles := defaultBatchMaxFiles
+	if v := os.Getenv("BATCH_MAX_FILES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxFiles = n
+		}
+	}
+
+	maxFileMB := defaultBatchMaxFileMB
+	if v := os.Getenv("BATCH_MAX_FILE_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxFileMB = n
+		}
+	}
+
+	maxUploadMB := defaultMaxUploadMB
+	if v := os.Getenv("MAX_UPLOAD_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxUploadMB = n
+		}
+	}
+
+	batchMaxUploadMB := defaultBatchMaxUploadMB
+	if v := os.Getenv("BATCH_MAX_UPLOAD_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			batchMaxUploadMB = n
+		}
+	}
+
+	batchMaxUnpackMB := defaultBatchMaxUnpackMB
+	if v := os.Getenv("BATCH_MAX_UNPACKED_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			batchMaxUnpackMB = n
+		}
+	}
+
+	var publicOverlay *transform.Pipeline
+	if v := os.Getenv("PUBLIC_OVERLAY"); v != "" {
+		pipeline, err := transform.Parse(v)
+		if err != nil {
+			log.Fatalf("Invalid PUBLIC_OVERLAY: %v", err)
+		}
+		publicOverlay = pipeline
+	}
+
+	// The first key signs generated URLs; see middleware.SignedURLMiddleware
+	keys, err := urlsign.ParseKeys(os.Getenv("URL_SIGNING_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid URL_SIGNING_KEYS: %v", err)
+	}
+	var signingKey *urlsign.Key
+	if len(keys) > 0 {
+		signingKey = &keys[0]
+	}
+
+	// Generated URLs always expire, so a leaked one is not valid forever
+	srcsetTTL, srcsetMaxTTL := defaultSrcsetTTL, defaultSrcsetMaxTTL
+	if v := os.Getenv("SRCSET_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			srcsetTTL = d
+		}
+	}
+	if v := os.Getenv("SRCSET_MAX_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			srcsetMaxTTL = d
+		}
+	}
+	srcsetTTL = min(srcsetTTL, srcsetMaxTTL)
+
+	// Uploads with a bound key always go through its policy
+	keyPolicies := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("UPLOAD_POLICY_KEYS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, policy, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			log.Fatalf("Invalid UPLOAD_POLICY_KEYS entry %q (use key_name:policy)", pair)
+		}
+		if _, err := imageService.UploadPolicy(policy); err != nil {
+			log.Fatalf("Invalid UPLOAD_POLICY_KEYS: %v", err)
+		}
+		keyPolicies[name] = strings.ToLower(policy)
+	}
+
 	return &ImageHandler{
-		imageService: imageService,
+		imageService:     imageService,
+		batchMaxFiles:    maxFiles,
+		batchMaxFileSize: int64(maxFileMB) * 1024 * 1024,
+		maxUploadSize:    int64(maxUploadMB) * 1024 * 1024,
+		batchMaxUpload:   int64(batchMaxUploadMB) * 1024 * 1024,
+		batchMaxUnpacked: int64(batchMaxUnpackMB) * 1024 * 1024,
+		presetsOnly:      os.Getenv("TRANSFORM_PRESETS_ONLY") == "true",
+		publicOverlay:    publicOverlay,
+		signingKey:       signingKey,
+		srcsetTTL:        srcsetTTL,
+		srcsetMaxTTL:     srcsetMaxTTL,
+		baseURL:          strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/"),
+		keyPolicies:      keyPolicies,
 	}
 }
 
 func (h *ImageHandler) CreateImage(c *gin.Context) {
+	// Cap the body before the multipart parser spools it to memory or disk
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize)
 	file, err := c.FormFile("image")
 	if err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload exceeds %d MB", h.maxUploadSize>>20)})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
 
-	// Open the uploaded file
-	src, err := file.Open()
+	eager, pipelines, err := h.eagerPipelines(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts, ok := h.uploadOptions(c)
+	if !ok {
 		return
 	}
-	defer src.Close()
 
 	// Read the file content
-	buf := new(bytes.Buffer)
-	if _, err := io.Copy(buf, src); err != nil {
+	data, err := readFormFile(file)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
 		return
 	}
 
-	// Decode the image (supports multiple formats)
-	img, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	// Decode, convert to WebP and save
+	meta, err := h.imageService.Ingest(file.Filename, data, opts)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format"})
+		if respondBusy(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidImage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image format"})
+			return
+		}
+		if errors.Is(err, services.ErrAnimationLimit) || errors.Is(err, services.ErrImageTooLarge) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		var violation *services.PolicyError
+		if errors.As(err, &violation) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      fmt.Sprintf("Upload violates policy %q", violation.Policy),
+				"policy":     violation.Policy,
+				"violations": violation.Violations,
+			})
+			return
+		}
+		var dup *services.DuplicateError
+		if errors.As(err, &dup) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Image is a near-duplicate of an existing image", "duplicates": dup.Matches})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
 		return
 	}
+	h.imageService.Pregenerate(meta.ID, pipelines)
+
+	response := gin.H{
+		"id":     meta.ID,
+		"format": meta.Format,
+	}
+	if len(eager) > 0 {
+		response["eager"] = eager
+	}
+	if len(meta.NearDuplicates) > 0 {
+		response["near_duplicates"] = meta.NearDuplicates
+	}
+	c.JSON(http.StatusCreated, response)
+}
 
-	// Convert to WebP
-	webpBuf := new(bytes.Buffer)
-	if err := webp.Encode(webpBuf, img, &webp.Options{Lossless: true}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert to WebP"})
+func (h *ImageHandler) CreateImages(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.batchMaxUpload)
+	form, err := c.MultipartForm()
+	if err != nil {
+		if isBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload exceeds %d MB", h.batchMaxUpload>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
 		return
 	}
 
-	// Create image model
-	image := &models.Image{
-		ID:     strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)),
-		Data:   webpBuf.Bytes(),
-		Format: format,
+	_, pipelines, err := h.eagerPipelines(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts, ok := h.uploadOptions(c)
+	if !ok {
+		return
 	}
 
-	// Save to service
-	if err := h.imageService.AddImage(image); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+	// Collect uploaded files, expanding zip/tar archives into their entries
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// The budget caps files and bytes across the whole request while
+	// archives are expanded, not after
+	budget := &archive.Budget{Files: h.batchMaxFiles, Bytes: h.batchMaxUnpacked, MaxFileSize: h.batchMaxFileSize}
+	var files []services.UploadFile
+	for _, field := range fields {
+		for _, header := range form.File[field] {
+			if !archive.IsArchive(header.Filename) {
+				tooLarge := header.Size > h.batchMaxFileSize
+				size := header.Size
+				if tooLarge {
+					size = 0 // rejected unread
+				}
+				if err := budget.Take(size); err != nil {
+					h.respondBudget(c, err)
+					return
+				}
+				if tooLarge {
+					files = append(files, services.UploadFile{
+						Name: header.Filename,
+						Err:  fmt.Errorf("file exceeds %d bytes", h.batchMaxFileSize),
+					})
+					continue
+				}
+			}
+
+			data, err := readFormFile(header)
+			if err != nil {
+				files = append(files, services.UploadFile{Name: header.Filename, Err: err})
+				continue
+			}
+
+			if !archive.IsArchive(header.Filename) {
+				files = append(files, services.UploadFile{Name: header.Filename, Data: data})
+				continue
+			}
+
+			entries, err := archive.Extract(header.Filename, data, budget)
+			if errors.Is(err, archive.ErrTooManyFiles) || errors.Is(err, archive.ErrTooLarge) {
+				h.respondBudget(c, err)
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to read archive %s: %v", header.Filename, err)})
+				return
+			}
+			for _, entry := range entries {
+				files = append(files, services.UploadFile{Name: entry.Name, Data: entry.Data, Err: entry.Err})
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":     image.ID,
-		"format": format,
+	results := h.imageService.IngestBatch(files, opts)
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+			continue
+		}
+		h.imageService.Pregenerate(r.ID, pipelines)
+	}
+
+	status := http.StatusCreated
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
 	})
 }
 
+func (h *ImageHandler) ExportImages(c *gin.Context) {
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "tar" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported export format (use zip or tar)"})
+		return
+	}
+
+	var query services.ExportQuery
+	if ids := c.Query("ids"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+			if !services.ValidExportID(id) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid image ID %q", id)})
+				return
+			}
+			query.IDs = append(query.IDs, id)
+		}
+	}
+	prefix, hasPrefix := c.GetQuery("prefix")
+	query.Prefix = prefix
+	query.Tag = c.Query("tag")
+
+	var err error
+	if v := c.Query("modified_after"); v != "" {
+		if query.ModifiedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid modified_after %q (use RFC 3339)", v)})
+			return
+		}
+	}
+	if v := c.Query("modified_before"); v != "" {
+		if query.ModifiedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid modified_before %q (use RFC 3339)", v)})
+			return
+		}
+	}
+	hasRange := !query.ModifiedAfter.IsZero() || !query.ModifiedBefore.IsZero()
+	if len(query.IDs) == 0 && query.Tag == "" && !hasPrefix && !hasRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of ids, tag, prefix, modified_after or modified_before is required"})
+		return
+	}
+
+	c.Header("Content-Type", archive.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="images-export.%s"`, format))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so failures can only be logged
+	if err := h.imageService.Export(c.Writer, format, query); err != nil {
+		log.Printf("Warning: Export failed: %v", err)
+	}
+}
+
 func (h *ImageHandler) GetImage(c *gin.Context) {
 	id := c.Param("id")
+	if preset := c.Query("preset"); preset != "" {
+		h.servePreset(c, id, preset)
+		return
+	}
+	if h.overlayApplies(c) {
+		h.render(c, id, &transform.Pipeline{})
+		return
+	}
+
 	image, err := h.imageService.GetImage(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		if respondBusy(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		log.Printf("Warning: Failed to load %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load image"})
 		return
 	}
 
@@ -92,6 +419,235 @@ func (h *ImageHandler) GetImage(c *gin.Context) {
 	c.Data(http.StatusOK, "image/webp", image.Data)
 }
 
+// TransformImage serves GET /t/*path where the path is a transformation chain
+// followed by the image ID, e.g. /t/rs:fill:300:200/q:80/123456.
+func (h *ImageHandler) TransformImage(c *gin.Context) {
+	path := strings.Trim(c.Param("path"), "/")
+	chain, id := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		chain, id = path[:i], path[i+1:]
+	}
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image ID is required"})
+		return
+	}
+	if h.presetsOnly && !middleware.IsAuthenticated(c) && !middleware.IsSigned(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Arbitrary transformations are disabled, use a named preset"})
+		return
+	}
+
+	pipeline, err := transform.Parse(chain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.render(c, id, pipeline)
+}
+
+// PresetImage serves GET /p/:preset/:id.
+func (h *ImageHandler) PresetImage(c *gin.Context) {
+	h.servePreset(c, c.Param("id"), c.Param("preset"))
+}
+
+// ListFormats returns the accepted upload formats and the transformation
+// output formats.
+func (h *ImageHandler) ListFormats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"input":    services.InputFormats(),
+		"output":   services.OutputFormats(),
+		"archives": []string{"zip", "tar", "tar.gz", "tgz"},
+	})
+}
+
+// ListPresets returns the configured presets and their canonical chains.
+func (h *ImageHandler) ListPresets(c *gin.Context) {
+	presets := h.imageService.Presets()
+	result := make([]gin.H, 0, len(presets))
+	for _, name := range presets.Names() {
+		pipeline, _ := presets.Get(name)
+		result = append(result, gin.H{"name": name, "chain": pipeline.Canonical()})
+	}
+	c.JSON(http.StatusOK, gin.H{"presets": result})
+}
+
+// ListPolicies lists the upload policies and the one bound to the caller's
+// key, if any.
+func (h *ImageHandler) ListPolicies(c *gin.Context) {
+	response := gin.H{"policies": h.imageService.UploadPolicies()}
+	if bound := h.keyPolicies[middleware.KeyName(c)]; bound != "" {
+		response["key_policy"] = bound
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *ImageHandler) servePreset(c *gin.Context, id, preset string) {
+	pipeline, err := h.imageService.Preset(preset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown preset"})
+		return
+	}
+	h.render(c, id, pipeline)
+}
+
+// render serves an image through a pipeline, scaled by the dpr query
+// parameter, adding the public overlay for unauthenticated clients.
+func (h *ImageHandler) render(c *gin.Context, id string, pipeline *transform.Pipeline) {
+	if v := c.Query("dpr"); v != "" {
+		dpr, err := transform.ParseDPR(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pipeline = pipeline.WithDPR(dpr)
+	}
+	if h.overlayApplies(c) {
+		pipeline = pipeline.Then(h.publicOverlay)
+	}
+
+	rendered, err := h.imageService.Transform(id, pipeline)
+	if err != nil {
+		if respondBusy(c, err) {
+			return
+		}
+		if errors.Is(err, transform.ErrInvalidOperation) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		log.Printf("Warning: Failed to render %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render image"})
+		return
+	}
+
+	c.Data(http.StatusOK, rendered.ContentType, rendered.Data)
+}
+
+func (h *ImageHandler) overlayApplies(c *gin.Context) bool {
+	return h.publicOverlay != nil && !middleware.IsAuthenticated(c)
+}
+
+// GetPlaceholder serves a tiny WebP preview for progressive loading.
+func (h *ImageHandler) GetPlaceholder(c *gin.Context) {
+	rendered, err := h.imageService.GetPlaceholder(c.Param("id"))
+	if err != nil {
+		if respondBusy(c, err) {
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	c.Data(http.StatusOK, rendered.ContentType, rendered.Data)
+}
+
+// GetOriginal serves the upload as it was received, with its own content type.
+func (h *ImageHandler) GetOriginal(c *gin.Context) {
+	id := c.Param("id")
+	original, err := h.imageService.GetOriginal(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Original not found"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s%s"`, id, original.Extension))
+	c.Data(http.StatusOK, original.ContentType, original.Data)
+}
+
+func (h *ImageHandler) GetImageInfo(c *gin.Context) {
+	meta, err := h.imageService.GetInfo(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, meta)
+}
+
+func (h *ImageHandler) GetColors(c *gin.Context) {
+	colors, err := h.imageService.GetColors(c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrIndexDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Metadata index is not available"})
+		case errors.Is(err, services.ErrColorsMissing):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Colors have not been computed for this image; run imagectl reindex"})
+		default:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, colors)
+}
+
+func (h *ImageHandler) UpdateImage(c *gin.Context) {
+	var patch services.MetadataPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	meta, err := h.imageService.UpdateMetadata(c.Param("id"), patch)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrIndexDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Metadata index is not available"})
+		case errors.Is(err, services.ErrNotFound), errors.Is(err, index.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, meta)
+}
+
+func (h *ImageHandler) GetSimilar(c *gin.Context) {
+	var threshold, limit int
+	var err error
+	if v := c.Query("threshold"); v != "" {
+		if threshold, err = strconv.Atoi(v); err != nil || threshold < 1 || threshold > services.MaxSimilarityThreshold {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid threshold (use 1-%d)", services.MaxSimilarityThreshold)})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit %q", v)})
+			return
+		}
+	}
+
+	meta, matches, err := h.imageService.FindSimilar(c.Param("id"), threshold, limit)
+	if err != nil {
+		if respondBusy(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrIndexDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Metadata index is not available"})
+		case errors.Is(err, services.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search similar images"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      meta.ID,
+		"phash":   meta.PHash,
+		"similar": matches,
+	})
+}
+
 func (h *ImageHandler) DeleteImage(c *gin.Context) {
 	id := c.Param("id")
 	if err := h.imageService.DeleteImage(id); err != nil {
@@ -103,13 +659,193 @@ func (h *ImageHandler) DeleteImage(c *gin.Context) {
 }
 
 func (h *ImageHandler) ListImages(c *gin.Context) {
-	images := h.imageService.GetImages()
-	imageIDs := make([]string, len(images))
-	for i, img := range images {
-		imageIDs[i] = img.ID
+	query, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"images": imageIDs,
-	})
+	result, err := h.imageService.ListImages(query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrIndexDisabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tag filtering requires the metadata index"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list images"})
+		return
+	}
+
+	response := gin.H{"next_cursor": result.NextCursor}
+	if c.Query("include") == "metadata" {
+		response["images"] = result.Images
+	} else {
+		imageIDs := make([]string, len(result.Images))
+		for i, img := range result.Images {
+			imageIDs[i] = img.ID
+		}
+		response["images"] = imageIDs
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func parseListQuery(c *gin.Context) (services.ListQuery, error) {
+	query := services.ListQuery{
+		Prefix:    c.Query("prefix"),
+		Tag:       c.Query("tag"),
+		Cursor:    c.Query("cursor"),
+		Format:    c.Query("format"),
+		Sort:      c.DefaultQuery("sort", "id"),
+		Desc:      c.Query("order") == "desc",
+		Secondary: c.Query("source") == "secondary",
+	}
+
+	switch query.Sort {
+	case "id", "size", "modified":
+	default:
+		return query, fmt.Errorf("invalid sort %q (use id, size or modified)", query.Sort)
+	}
+	if order := c.Query("order"); order != "" && order != "asc" && order != "desc" {
+		return query, fmt.Errorf("invalid order %q (use asc or desc)", order)
+	}
+
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := c.Query("min_size"); v != "" {
+		if query.MinSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid min_size %q", v)
+		}
+	}
+	if v := c.Query("max_size"); v != "" {
+		if query.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid max_size %q", v)
+		}
+	}
+	if v := c.Query("modified_after"); v != "" {
+		if query.ModifiedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid modified_after %q (use RFC 3339)", v)
+		}
+	}
+	if v := c.Query("modified_before"); v != "" {
+		if query.ModifiedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid modified_before %q (use RFC 3339)", v)
+		}
+	}
+
+	return query, nil
+}
+
+// eagerPipelines resolves the presets to render right after an upload: the
+// EAGER_PRESETS defaults plus those named in the comma-separated "eager" form
+// field. They are rendered the way public clients request them, including
+// the public overlay.
+func (h *ImageHandler) eagerPipelines(c *gin.Context) ([]string, []*transform.Pipeline, error) {
+	names := append([]string{}, h.imageService.EagerPresets()...)
+	for _, name := range strings.Split(c.PostForm("eager"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	pipelines := make([]*transform.Pipeline, 0, len(names))
+	for _, name := range names {
+		pipeline, err := h.imageService.Preset(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.publicOverlay != nil {
+			pipeline = pipeline.Then(h.publicOverlay)
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return names, pipelines, nil
+}
+
+// uploadOptions reads the per-upload "duplicates", "duplicate_threshold"
+// and "policy" form fields. A key bound to a policy cannot choose another
+// one. On failure it has already responded.
+func (h *ImageHandler) uploadOptions(c *gin.Context) (services.UploadOptions, bool) {
+	var opts services.UploadOptions
+	switch policy := c.PostForm("duplicates"); policy {
+	case "", services.DuplicatesAllow, services.DuplicatesFlag, services.DuplicatesReject:
+		opts.Duplicates = policy
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid duplicates %q (use allow, flag or reject)", policy)})
+		return opts, false
+	}
+	if v := c.PostForm("duplicate_threshold"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > services.MaxSimilarityThreshold {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid duplicate_threshold %q (use 1-%d)", v, services.MaxSimilarityThreshold)})
+			return opts, false
+		}
+		opts.DuplicateThreshold = n
+	}
+
+	name := strings.ToLower(strings.TrimSpace(c.PostForm("policy")))
+	if bound := h.keyPolicies[middleware.KeyName(c)]; bound != "" {
+		if name != "" && name != bound {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key is restricted to upload policy %q", bound)})
+			return opts, false
+		}
+		name = bound
+	}
+	if name != "" {
+		policy, err := h.imageService.UploadPolicy(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return opts, false
+		}
+		opts.Policy = policy
+	}
+	return opts, true
+}
+
+// respondBusy answers 503 with a Retry-After estimate when err is a full
+// processing queue.
+func respondBusy(c *gin.Context, err error) bool {
+	var full *pool.QueueFullError
+	if !errors.As(err, &full) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(full.RetryAfter.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+	return true
+}
+
+// respondBudget reports a batch that exceeded its file count or unpacked
+// size while being read.
+func (h *ImageHandler) respondBudget(c *gin.Context, err error) {
+	if errors.Is(err, archive.ErrTooManyFiles) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many files (max %d)", h.batchMaxFiles)})
+		return
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Uncompressed files exceed %d MB", h.batchMaxUnpacked>>20)})
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	src, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, src); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
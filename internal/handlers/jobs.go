@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kartex/imageprovider/internal/services"
+)
+
+// StartReencode starts a background job re-encoding masters or pre-rendering
+// variants across the library.
+func (h *ImageHandler) StartReencode(c *gin.Context) {
+	var profile services.ReencodeProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	status, err := h.imageService.StartReencode(profile)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, status)
+}
+
+func (h *ImageHandler) GetReencode(c *gin.Context) {
+	status, err := h.imageService.ReencodeStatus()
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ImageHandler) PauseReencode(c *gin.Context) {
+	status, err := h.imageService.PauseReencode()
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ImageHandler) ResumeReencode(c *gin.Context) {
+	status, err := h.imageService.ResumeReencode()
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ImageHandler) CancelReencode(c *gin.Context) {
+	status, err := h.imageService.CancelReencode()
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func respondJobError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidProfile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNoJob):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No re-encode job"})
+	case errors.Is(err, services.ErrJobActive), errors.Is(err, services.ErrJobState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job"})
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getOriginal(h *ImageHandler, id string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/images/"+id+"/original", nil)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	h.GetOriginal(c)
+	return rec
+}
+
+func TestGetOriginal(t *testing.T) {
+	dir := t.TempDir()
+	h := newTestHandlerIn(t, dir)
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec := upload(t, h.CreateImage, "/images", "image", "photo.jpg", buf.Bytes()); rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := getOriginal(h, "photo")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `inline; filename="photo.jpg"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), buf.Bytes()) {
+		t.Error("original bytes differ from the upload")
+	}
+
+	if rec := getOriginal(h, "missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing image: status = %d, want 404", rec.Code)
+	}
+
+	// Replacing the image while originals are not kept drops the stale one
+	t.Setenv("KEEP_ORIGINALS", "false")
+	h = newTestHandlerIn(t, dir)
+	if rec := upload(t, h.CreateImage, "/images", "image", "photo.png", testPNG(t)); rec.Code != http.StatusCreated {
+		t.Fatalf("replace: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := getOriginal(h, "photo"); rec.Code != http.StatusNotFound {
+		t.Errorf("after replacing: status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kartex/imageprovider/internal/transform"
+	"github.com/kartex/imageprovider/pkg/urlsign"
+)
+
+const maxSrcsetCandidates = 10
+
+var defaultDensities = []float64{1, 2}
+
+type srcsetCandidate struct {
+	URL        string  `json:"url"`
+	Width      int     `json:"width,omitempty"`
+	Density    float64 `json:"density,omitempty"`
+	descriptor string
+}
+
+// GetSrcset serves GET /images/:id/srcset. With widths=320,640,1280 it returns
+// width descriptors for /t/ resizes, capped at the original width; with
+// preset=name it returns density descriptors for the preset at each of
+// densities=1,2 (the default). URLs are signed when signing keys are set, so
+// the route requires the API key, and expire after ttl (SRCSET_TTL by default).
+func (h *ImageHandler) GetSrcset(c *gin.Context) {
+	id := c.Param("id")
+	info, err := h.imageService.GetInfo(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	ttl := h.srcsetTTL
+	if v := c.Query("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 || ttl > h.srcsetMaxTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ttl must be a positive duration up to %s, such as 24h", h.srcsetMaxTTL)})
+			return
+		}
+	}
+	expires := time.Now().Add(ttl)
+
+	var candidates []srcsetCandidate
+	sizes := c.Query("sizes")
+	if preset := c.Query("preset"); preset != "" {
+		candidates, err = h.presetCandidates(c, info.ID, preset)
+	} else {
+		candidates, err = h.widthCandidates(c, info.ID, info.Width)
+		if err == nil && sizes == "" {
+			largest := candidates[len(candidates)-1].Width
+			sizes = fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", largest, largest)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parts := make([]string, len(candidates))
+	for i := range candidates {
+		if candidates[i].URL, err = h.publicURL(candidates[i].URL, expires); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign URL"})
+			return
+		}
+		parts[i] = candidates[i].URL + " " + candidates[i].descriptor
+	}
+
+	response := gin.H{
+		"src":        candidates[len(candidates)-1].URL,
+		"srcset":     strings.Join(parts, ", "),
+		"candidates": candidates,
+	}
+	if sizes != "" {
+		response["sizes"] = sizes
+	}
+	// PureJSON keeps "&" in URLs readable instead of escaping it as \u0026
+	c.PureJSON(http.StatusOK, response)
+}
+
+func (h *ImageHandler) widthCandidates(c *gin.Context, id string, originalWidth int) ([]srcsetCandidate, error) {
+	spec := c.Query("widths")
+	if spec == "" {
+		return nil, errors.New("widths or preset is required")
+	}
+
+	var suffix string
+	if q := c.Query("quality"); q != "" {
+		suffix += "/q:" + q
+	}
+	if f := c.Query("format"); f != "" {
+		suffix += "/f:" + f
+	}
+	if _, err := transform.Parse(suffix); err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	var widths []int
+	for _, v := range strings.Split(spec, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || w < 1 || w > transform.MaxDimension {
+			return nil, fmt.Errorf("invalid width %q", v)
+		}
+		// Never upscale: larger widths collapse into the original width
+		if originalWidth > 0 {
+			w = min(w, originalWidth)
+		}
+		if !seen[w] {
+			seen[w] = true
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) > maxSrcsetCandidates {
+		return nil, fmt.Errorf("at most %d widths are allowed", maxSrcsetCandidates)
+	}
+	sort.Ints(widths)
+
+	candidates := make([]srcsetCandidate, len(widths))
+	for i, w := range widths {
+		candidates[i] = srcsetCandidate{
+			URL:        fmt.Sprintf("/t/rs:%d:0%s/%s", w, suffix, id),
+			Width:      w,
+			descriptor: strconv.Itoa(w) + "w",
+		}
+	}
+	return candidates, nil
+}
+
+func (h *ImageHandler) presetCandidates(c *gin.Context, id, preset string) ([]srcsetCandidate, error) {
+	if _, err := h.imageService.Preset(preset); err != nil {
+		return nil, err
+	}
+
+	densities := defaultDensities
+	if spec := c.Query("densities"); spec != "" {
+		densities = nil
+		for _, v := range strings.Split(spec, ",") {
+			dpr, err := transform.ParseDPR(strings.TrimSpace(v))
+			if err != nil {
+				return nil, err
+			}
+			densities = append(densities, dpr)
+		}
+		if len(densities) > maxSrcsetCandidates {
+			return nil, fmt.Errorf("at most %d densities are allowed", maxSrcsetCandidates)
+		}
+		sort.Float64s(densities)
+	}
+
+	candidates := make([]srcsetCandidate, 0, len(densities))
+	for _, dpr := range densities {
+		if len(candidates) > 0 && candidates[len(candidates)-1].Density == dpr {
+			continue
+		}
+		d := strconv.FormatFloat(dpr, 'f', -1, 64)
+		url := "/p/" + strings.ToLower(preset) + "/" + id
+		if dpr != 1 {
+			url += "?dpr=" + d
+		}
+		candidates = append(candidates, srcsetCandidate{URL: url, Density: dpr, descriptor: d + "x"})
+	}
+	return candidates, nil
+}
+
+// publicURL signs path when URL signing is configured and prefixes it with
+// PUBLIC_BASE_URL.
+func (h *ImageHandler) publicURL(path string, expires time.Time) (string, error) {
+	if h.signingKey != nil {
+		signed, err := urlsign.Sign(*h.signingKey, path, expires)
+		if err != nil {
+			return "", err
+		}
+		path = signed
+	}
+	return h.baseURL + path, nil
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetStats reports processing pool queues and timings.
+func (h *ImageHandler) GetStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.imageService.Stats())
+}
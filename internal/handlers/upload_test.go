@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kartex/imageprovider/internal/services"
+	"github.com/kartex/imageprovider/internal/storage"
+	"github.com/kartex/imageprovider/internal/testutil"
+)
+
+func newTestHandler(t *testing.T) *ImageHandler {
+	t.Helper()
+	return newTestHandlerIn(t, t.TempDir())
+}
+
+// newTestHandlerIn returns a handler storing images in dir.
+func newTestHandlerIn(t *testing.T, dir string) *ImageHandler {
+	t.Helper()
+	t.Setenv("MAX_UPLOAD_SIZE_MB", "1")
+	t.Setenv("BATCH_MAX_UPLOAD_SIZE_MB", "1")
+	fs, err := storage.NewFileSystemStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewImageHandler(services.NewImageService(fs, nil, nil))
+}
+
+func multipartBody(t *testing.T, field, filename string, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+	return body, w.FormDataContentType()
+}
+
+func upload(t *testing.T, h gin.HandlerFunc, path, field, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	body, contentType := multipartBody(t, field, filename, data)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, path, body)
+	c.Request.Header.Set("Content-Type", contentType)
+	h(c)
+	return rec
+}
+
+func TestCreateImageBodyTooLarge(t *testing.T) {
+	h := newTestHandler(t)
+	rec := upload(t, h.CreateImage, "/images", "image", "big.png", make([]byte, 2<<20))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateImagesBodyTooLarge(t *testing.T) {
+	h := newTestHandler(t)
+	rec := upload(t, h.CreateImages, "/images/batch", "files", "big.png", make([]byte, 2<<20))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateImageDecompressionBomb(t *testing.T) {
+	h := newTestHandler(t)
+	rec := upload(t, h.CreateImage, "/images", "image", "bomb.png", testutil.PNGHeader(50000, 50000))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIsBodyTooLarge(t *testing.T) {
+	if !isBodyTooLarge(&http.MaxBytesError{Limit: 1}) {
+		t.Error("MaxBytesError not detected")
+	}
+	if isBodyTooLarge(http.ErrMissingFile) {
+		t.Error("ErrMissingFile reported as too large")
+	}
+}
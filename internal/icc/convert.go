@@ -0,0 +1,173 @@
+package icc
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// xyzD50ToSRGB maps D50 PCS values to linear sRGB (Bradford adapted).
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+const encodeTableSize = 4096
+
+// curve is a tone reproduction curve mapping encoded values to linear light.
+type curve struct {
+	gamma  float64
+	table  []float64
+	params []float64 // parametric curve: g, a, b, c, d, e, f
+	kind   int
+}
+
+func readCurve(raw []byte, e tagEntry) (curve, error) {
+	d := tagData(raw, e)
+	if len(d) < 12 {
+		return curve{}, ErrUnsupported
+	}
+
+	switch string(d[0:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(d[8:]))
+		switch {
+		case n == 0:
+			return curve{gamma: 1}, nil
+		case n == 1 && len(d) >= 14:
+			return curve{gamma: float64(binary.BigEndian.Uint16(d[12:])) / 256}, nil
+		case len(d) >= 12+n*2:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(d[12+i*2:])) / 65535
+			}
+			return curve{table: table}, nil
+		}
+	case "para":
+		kind := int(binary.BigEndian.Uint16(d[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if kind >= len(counts) || len(d) < 12+counts[kind]*4 {
+			return curve{}, ErrUnsupported
+		}
+		params := make([]float64, 7)
+		for i := 0; i < counts[kind]; i++ {
+			params[i] = s15f16(d[12+i*4:])
+		}
+		return curve{params: params, kind: kind}, nil
+	}
+	return curve{}, ErrUnsupported
+}
+
+func (c curve) eval(x float64) float64 {
+	switch {
+	case c.table != nil:
+		pos := x * float64(len(c.table)-1)
+		i := int(pos)
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		frac := pos - float64(i)
+		return c.table[i]*(1-frac) + c.table[i+1]*frac
+	case c.params != nil:
+		g, a, b, cc, d, e, f := c.params[0], c.params[1], c.params[2], c.params[3], c.params[4], c.params[5], c.params[6]
+		switch c.kind {
+		case 0:
+			return math.Pow(x, g)
+		case 1:
+			if x >= -b/a {
+				return math.Pow(a*x+b, g)
+			}
+			return 0
+		case 2:
+			if x >= -b/a {
+				return math.Pow(a*x+b, g) + cc
+			}
+			return cc
+		case 3:
+			if x >= d {
+				return math.Pow(a*x+b, g)
+			}
+			return cc * x
+		case 4:
+			if x >= d {
+				return math.Pow(a*x+b, g) + e
+			}
+			return cc*x + f
+		}
+	}
+	return math.Pow(x, c.gamma)
+}
+
+// finite reports whether the curve yields a finite value for every 8-bit
+// input. Crafted parametric curves can produce NaN, e.g. a negative base
+// raised to a fractional power.
+func (c curve) finite() bool {
+	for v := 0; v < 256; v++ {
+		y := c.eval(float64(v) / 255)
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToSRGB converts the image's pixels from the profile's color space to
+// sRGB. Alpha is preserved.
+func (p *Profile) ToSRGB(img image.Image) image.Image {
+	var m [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				m[r][c] += xyzD50ToSRGB[r][k] * p.toXYZ[k][c]
+			}
+		}
+	}
+
+	var linear [3][256]float64
+	for ch := 0; ch < 3; ch++ {
+		for v := 0; v < 256; v++ {
+			linear[ch][v] = p.curves[ch].eval(float64(v) / 255)
+		}
+	}
+	var encode [encodeTableSize + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(linearToSRGB(float64(i)/encodeTableSize) * 255))
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		r := linear[0][dst.Pix[i]]
+		g := linear[1][dst.Pix[i+1]]
+		bl := linear[2][dst.Pix[i+2]]
+		for ch := 0; ch < 3; ch++ {
+			v := m[ch][0]*r + m[ch][1]*g + m[ch][2]*bl
+			// NaN fails both comparisons below, so catch it first
+			if v < 0 || math.IsNaN(v) {
+				v = 0
+			} else if v > 1 {
+				v = 1
+			}
+			dst.Pix[i+ch] = encode[int(v*encodeTableSize+0.5)]
+		}
+	}
+	return dst
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
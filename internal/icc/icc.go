@@ -0,0 +1,281 @@
+// Package icc extracts embedded ICC color profiles and converts pixels from
+// matrix/TRC RGB profiles (Adobe RGB, Display P3, ProPhoto, ...) to sRGB.
+package icc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	ErrNoProfile   = errors.New("no ICC profile")
+	ErrUnsupported = errors.New("unsupported ICC profile")
+)
+
+const (
+	maxProfileSize   = 4 << 20
+	jpegICCSignature = "ICC_PROFILE\x00"
+)
+
+// Profile is a parsed RGB matrix/TRC profile.
+type Profile struct {
+	Description string
+	// toXYZ maps linear RGB to the D50 profile connection space
+	toXYZ  [3][3]float64
+	curves [3]curve
+}
+
+// Extract returns the raw ICC profile embedded in a JPEG, PNG or WebP file.
+func Extract(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return extractJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return extractPNG(data)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return extractWebP(data)
+	}
+	return nil, ErrNoProfile
+}
+
+// Describe returns the profile description without requiring the profile
+// to be convertible.
+func Describe(raw []byte) string {
+	tags, err := readTags(raw)
+	if err != nil {
+		return ""
+	}
+	return description(raw, tags)
+}
+
+// Parse reads an RGB matrix/TRC profile. LUT-based and non-RGB profiles
+// return ErrUnsupported.
+func Parse(raw []byte) (*Profile, error) {
+	tags, err := readTags(raw)
+	if err != nil {
+		return nil, err
+	}
+	if string(raw[16:20]) != "RGB " || string(raw[20:24]) != "XYZ " {
+		return nil, ErrUnsupported
+	}
+
+	p := &Profile{Description: description(raw, tags)}
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := readXYZ(raw, tags[sig])
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		for row := 0; row < 3; row++ {
+			p.toXYZ[row][i] = xyz[row]
+		}
+	}
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		c, err := readCurve(raw, tags[sig])
+		if err != nil || !c.finite() {
+			return nil, ErrUnsupported
+		}
+		p.curves[i] = c
+	}
+	return p, nil
+}
+
+// IsSRGB reports whether the profile is (close enough to) sRGB that no
+// conversion is needed.
+func (p *Profile) IsSRGB() bool {
+	if strings.Contains(strings.ToLower(p.Description), "srgb") {
+		return true
+	}
+	srgb := [3][3]float64{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	}
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			if math.Abs(p.toXYZ[r][c]-srgb[r][c]) > 0.002 {
+				return false
+			}
+		}
+	}
+	for _, c := range p.curves {
+		if math.Abs(c.eval(0.5)-srgbToLinear(0.5)) > 0.005 {
+			return false
+		}
+	}
+	return true
+}
+
+type tagEntry struct {
+	offset, size uint32
+}
+
+func readTags(raw []byte) (map[string]tagEntry, error) {
+	if len(raw) < 132 || string(raw[36:40]) != "acsp" {
+		return nil, fmt.Errorf("invalid ICC profile header")
+	}
+	n := binary.BigEndian.Uint32(raw[128:])
+	if n > 1000 || 132+int(n)*12 > len(raw) {
+		return nil, fmt.Errorf("invalid ICC tag table")
+	}
+
+	tags := make(map[string]tagEntry, n)
+	for i := 0; i < int(n); i++ {
+		p := 132 + i*12
+		e := tagEntry{
+			offset: binary.BigEndian.Uint32(raw[p+4:]),
+			size:   binary.BigEndian.Uint32(raw[p+8:]),
+		}
+		if uint64(e.offset)+uint64(e.size) > uint64(len(raw)) {
+			continue
+		}
+		tags[string(raw[p:p+4])] = e
+	}
+	return tags, nil
+}
+
+func tagData(raw []byte, e tagEntry) []byte {
+	if e.size == 0 {
+		return nil
+	}
+	return raw[e.offset : e.offset+e.size]
+}
+
+func description(raw []byte, tags map[string]tagEntry) string {
+	d := tagData(raw, tags["desc"])
+	if len(d) < 12 {
+		return ""
+	}
+	switch string(d[0:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(d[8:]))
+		if n <= 0 || 12+n > len(d) {
+			return ""
+		}
+		return strings.TrimRight(string(d[12:12+n]), "\x00")
+	case "mluc":
+		if len(d) < 28 || binary.BigEndian.Uint32(d[8:]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(d[20:]))
+		offset := int(binary.BigEndian.Uint32(d[24:]))
+		if offset+length > len(d) {
+			return ""
+		}
+		u := make([]uint16, length/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(d[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+	return ""
+}
+
+func readXYZ(raw []byte, e tagEntry) ([3]float64, error) {
+	d := tagData(raw, e)
+	if len(d) < 20 || string(d[0:4]) != "XYZ " {
+		return [3]float64{}, ErrUnsupported
+	}
+	return [3]float64{s15f16(d[8:]), s15f16(d[12:]), s15f16(d[16:])}, nil
+}
+
+func s15f16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func extractJPEG(data []byte) ([]byte, error) {
+	// Profiles larger than a segment are split into numbered chunks
+	chunks := make(map[int][]byte)
+	p := 2
+	for p+4 <= len(data) {
+		if data[p] != 0xFF {
+			break
+		}
+		marker := data[p+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if length < 2 || p+2+length > len(data) {
+			break
+		}
+		payload := data[p+4 : p+2+length]
+		if marker == 0xE2 && bytes.HasPrefix(payload, []byte(jpegICCSignature)) && len(payload) > len(jpegICCSignature)+2 {
+			seq := int(payload[len(jpegICCSignature)])
+			chunks[seq] = payload[len(jpegICCSignature)+2:]
+		}
+		p += 2 + length
+	}
+	if len(chunks) == 0 {
+		return nil, ErrNoProfile
+	}
+
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	var profile []byte
+	for _, seq := range seqs {
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile, nil
+}
+
+func extractPNG(data []byte) ([]byte, error) {
+	p := 8
+	for p+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		typ := string(data[p+4 : p+8])
+		if length < 0 || p+12+length > len(data) || typ == "IDAT" {
+			break
+		}
+		if typ == "iCCP" {
+			chunk := data[p+8 : p+8+length]
+			// profile name, null separator, compression method, zlib data
+			i := bytes.IndexByte(chunk, 0)
+			if i < 0 || i+2 > len(chunk) {
+				return nil, ErrNoProfile
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[i+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			profile, err := io.ReadAll(io.LimitReader(zr, maxProfileSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(profile) > maxProfileSize {
+				return nil, fmt.Errorf("ICC profile exceeds %d bytes", maxProfileSize)
+			}
+			return profile, nil
+		}
+		p += 12 + length
+	}
+	return nil, ErrNoProfile
+}
+
+func extractWebP(data []byte) ([]byte, error) {
+	p := 12
+	for p+8 <= len(data) {
+		typ := string(data[p : p+4])
+		length := int(binary.LittleEndian.Uint32(data[p+4:]))
+		if length < 0 || p+8+length > len(data) {
+			break
+		}
+		if typ == "ICCP" {
+			return data[p+8 : p+8+length], nil
+		}
+		p += 8 + length + length%2
+	}
+	return nil, ErrNoProfile
+}
@@ -0,0 +1,180 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Display P3 primaries adapted to D50, as in Apple's profile
+var displayP3 = [3][3]float64{
+	{0.515121, 0.291977, 0.157104},
+	{0.241196, 0.692245, 0.066574},
+	{-0.001053, 0.041885, 0.784073},
+}
+
+// buildProfile writes an RGB matrix/TRC profile with the given D50 matrix
+// (columns are the red, green and blue colorants) and sRGB tone curves.
+func buildProfile(desc string, m [3][3]float64) []byte {
+	be := binary.BigEndian
+	s15 := func(b []byte, v float64) []byte { return be.AppendUint32(b, uint32(int32(v*65536))) }
+
+	descTag := []byte("desc\x00\x00\x00\x00")
+	descTag = be.AppendUint32(descTag, uint32(len(desc)+1))
+	descTag = append(descTag, desc+"\x00"...)
+
+	trc := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		trc = s15(trc, v)
+	}
+
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{{"desc", descTag}}
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			xyz = s15(xyz, m[row][i])
+		}
+		tags = append(tags, tag{sig, xyz})
+	}
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags = append(tags, tag{sig, trc})
+	}
+
+	header := make([]byte, 128)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := 128 + 4 + len(tags)*12
+	for _, t := range tags {
+		table = append(table, t.sig...)
+		table = be.AppendUint32(table, uint32(offset+len(data)))
+		table = be.AppendUint32(table, uint32(len(t.data)))
+		data = append(data, t.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	profile := append(append(header, table...), data...)
+	be.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func TestDisplayP3ToSRGB(t *testing.T) {
+	p, err := Parse(buildProfile("Display P3", displayP3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "Display P3" || p.IsSRGB() {
+		t.Fatalf("profile = %q, IsSRGB = %v", p.Description, p.IsSRGB())
+	}
+
+	// Pure sRGB red and green expressed in Display P3, and neutrals, which
+	// the shared white point leaves unchanged
+	tests := []struct {
+		in, want color.NRGBA
+	}{
+		{color.NRGBA{234, 51, 35, 255}, color.NRGBA{255, 0, 0, 255}},
+		{color.NRGBA{117, 251, 76, 255}, color.NRGBA{0, 255, 0, 255}},
+		{color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		{color.NRGBA{128, 128, 128, 64}, color.NRGBA{128, 128, 128, 64}},
+		{color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 0, 255}},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(tests), 1))
+	for i, tt := range tests {
+		img.SetNRGBA(i, 0, tt.in)
+	}
+	out := p.ToSRGB(img).(*image.NRGBA)
+	for i, tt := range tests {
+		// The inputs are rounded to 8 bits and the sRGB curve is steep near
+		// zero, so channels that should be 0 land within a few levels
+		got := out.NRGBAAt(i, 0)
+		if !near(got, tt.want, 3) {
+			t.Errorf("%v = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSRGBProfile(t *testing.T) {
+	srgb := [3][3]float64{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	}
+	p, err := Parse(buildProfile("Generic RGB", srgb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsSRGB() {
+		t.Error("sRGB colorants not recognised")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	profile := buildProfile("Display P3", displayP3)
+	got, err := Extract(jpegWithProfile(profile, 100))
+	if err != nil || !bytes.Equal(got, profile) {
+		t.Errorf("JPEG: %d bytes, %v", len(got), err)
+	}
+	if _, err := Extract([]byte("GIF89a")); err != ErrNoProfile {
+		t.Errorf("GIF: err = %v", err)
+	}
+}
+
+// jpegWithProfile splits the profile across APP2 segments of at most chunk
+// bytes, writing them out of order.
+func jpegWithProfile(profile []byte, chunk int) []byte {
+	var chunks [][]byte
+	for len(profile) > 0 {
+		n := min(chunk, len(profile))
+		chunks = append(chunks, profile[:n])
+		profile = profile[n:]
+	}
+	out := []byte{0xFF, 0xD8}
+	for i := len(chunks) - 1; i >= 0; i-- {
+		payload := append([]byte(jpegICCSignature), byte(i+1), byte(len(chunks)))
+		payload = append(payload, chunks[i]...)
+		out = append(out, 0xFF, 0xE2)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+		out = append(out, payload...)
+	}
+	return append(out, 0xFF, 0xD9)
+}
+
+func near(a, b color.NRGBA, tolerance int) bool {
+	d := func(x, y uint8) bool { return int(x)-int(y) <= tolerance && int(y)-int(x) <= tolerance }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && a.A == b.A
+}
+
+func FuzzParse(f *testing.F) {
+	profile := buildProfile("Display P3", displayP3)
+	f.Add(profile)
+	f.Add(profile[:140])
+	f.Add(jpegWithProfile(profile, 64))
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 16)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		raw := data
+		if extracted, err := Extract(data); err == nil {
+			raw = extracted
+		}
+		Describe(raw)
+		p, err := Parse(raw)
+		if err != nil {
+			return
+		}
+		p.IsSRGB()
+		p.ToSRGB(img)
+	})
+}
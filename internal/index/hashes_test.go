@@ -0,0 +1,70 @@
+package index
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/phash"
+)
+
+func similarIDs(i *Index, hash phash.Hash, threshold int, exclude string) []string {
+	var ids []string
+	for _, m := range i.Similar(hash, threshold, exclude) {
+		ids = append(ids, m.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSimilarTracksWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = idx.PutMany([]*models.Metadata{
+		{ID: "a", PHash: "0000000000000000"},
+		{ID: "b", PHash: "0000000000000003"}, // 2 bits from a
+		{ID: "c", PHash: "00000000000000ff"}, // 8 bits from a
+		{ID: "d"},                            // no hash yet
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := similarIDs(idx, 0, 2, "a"); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("Similar = %v, want [b]", got)
+	}
+	if got := similarIDs(idx, 0, 8, ""); len(got) != 3 {
+		t.Fatalf("Similar = %v, want a, b and c", got)
+	}
+
+	// Updates, new hashes and deletes are reflected immediately
+	if _, err := idx.Update("c", func(m *models.Metadata) error { m.PHash = "0000000000000001"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Update("d", func(m *models.Metadata) error { m.PHash = "ffffffffffffffff"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := similarIDs(idx, 0, 2, ""); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("Similar = %v, want [a c]", got)
+	}
+
+	// The table is rebuilt from the database on open
+	idx.Close()
+	if idx, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if got := similarIDs(idx, ^phash.Hash(0), 0, ""); len(got) != 1 || got[0] != "d" {
+		t.Fatalf("Similar after reopen = %v, want [d]", got)
+	}
+	if got := similarIDs(idx, 0, 64, ""); len(got) != 3 {
+		t.Fatalf("Similar after reopen = %v, want a, c and d", got)
+	}
+}
@@ -0,0 +1,265 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("image not found in index")
+
+var (
+	imagesBucket = []byte("images")
+	tagsBucket   = []byte("tags")
+)
+
+// Index is an embedded bbolt database of image metadata keyed by ID. Tags are
+// additionally kept in a "tag\x00id" bucket so tag queries avoid a full scan,
+// and perceptual hashes in memory for similarity searches (see hashes.go).
+type Index struct {
+	db *bolt.DB
+
+	// writeMu orders writes so the hash table sees them in commit order
+	writeMu sync.Mutex
+	hashes  hashTable
+}
+
+func Open(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("index %s is locked by another process", path)
+		}
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{imagesBucket, tagsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	i := &Index{db: db}
+	if err := db.View(i.hashes.load); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+func (i *Index) Get(id string) (*models.Metadata, error) {
+	var meta *models.Metadata
+	err := i.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(imagesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		meta = &models.Metadata{}
+		return json.Unmarshal(data, meta)
+	})
+	return meta, err
+}
+
+func (i *Index) Put(meta *models.Metadata) error {
+	return i.PutMany([]*models.Metadata{meta})
+}
+
+// Update applies fn to the stored metadata inside a single transaction.
+func (i *Index) Update(id string, fn func(meta *models.Metadata) error) (*models.Metadata, error) {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	var meta *models.Metadata
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		old := images.Get([]byte(id))
+		if old == nil {
+			return ErrNotFound
+		}
+		meta = &models.Metadata{}
+		if err := json.Unmarshal(old, meta); err != nil {
+			return err
+		}
+		if err := fn(meta); err != nil {
+			return err
+		}
+
+		if err := removeTags(tx, old); err != nil {
+			return err
+		}
+		tags := tx.Bucket(tagsBucket)
+		for _, tag := range meta.Tags {
+			if err := tags.Put(tagKey(tag, id), nil); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return images.Put([]byte(id), data)
+	})
+	if err == nil {
+		i.hashes.apply([]*models.Metadata{meta})
+	}
+	return meta, err
+}
+
+func (i *Index) Delete(id string) error {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		if err := removeTags(tx, images.Get([]byte(id))); err != nil {
+			return err
+		}
+		return images.Delete([]byte(id))
+	})
+	if err == nil {
+		i.hashes.delete(id)
+	}
+	return err
+}
+
+// Scan calls fn for each image in ID order starting after cursor, restricted
+// to IDs with the given prefix. Returning false from fn stops the scan.
+func (i *Index) Scan(prefix, cursor string, fn func(meta *models.Metadata) bool) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(imagesBucket).Cursor()
+
+		start := []byte(prefix)
+		if cursor > prefix {
+			start = []byte(cursor)
+		}
+
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if cursor != "" && string(k) <= cursor {
+				continue
+			}
+			meta := &models.Metadata{}
+			if err := json.Unmarshal(v, meta); err != nil {
+				return fmt.Errorf("corrupt index entry %q: %w", k, err)
+			}
+			if !fn(meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// ScanTag is like Scan but only visits images carrying the tag.
+func (i *Index) ScanTag(tag, cursor string, fn func(meta *models.Metadata) bool) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		c := tx.Bucket(tagsBucket).Cursor()
+
+		prefix := tagKey(tag, "")
+		start := prefix
+		if cursor != "" {
+			start = tagKey(tag, cursor)
+		}
+
+		for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := string(k[len(prefix):])
+			if cursor != "" && id <= cursor {
+				continue
+			}
+			v := images.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+			meta := &models.Metadata{}
+			if err := json.Unmarshal(v, meta); err != nil {
+				return fmt.Errorf("corrupt index entry %q: %w", id, err)
+			}
+			if !fn(meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (i *Index) Count() (int, error) {
+	var n int
+	err := i.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(imagesBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// PutMany stores several entries in one transaction, which is much faster
+// than individual Puts when rebuilding large indexes.
+func (i *Index) PutMany(entries []*models.Metadata) error {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		tags := tx.Bucket(tagsBucket)
+		for _, meta := range entries {
+			data, err := json.Marshal(meta)
+			if err != nil {
+				return err
+			}
+			if err := removeTags(tx, images.Get([]byte(meta.ID))); err != nil {
+				return err
+			}
+			for _, tag := range meta.Tags {
+				if err := tags.Put(tagKey(tag, meta.ID), nil); err != nil {
+					return err
+				}
+			}
+			if err := images.Put([]byte(meta.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		i.hashes.apply(entries)
+	}
+	return err
+}
+
+func removeTags(tx *bolt.Tx, old []byte) error {
+	if old == nil {
+		return nil
+	}
+	var meta models.Metadata
+	if err := json.Unmarshal(old, &meta); err != nil {
+		return err
+	}
+	tags := tx.Bucket(tagsBucket)
+	for _, tag := range meta.Tags {
+		if err := tags.Delete(tagKey(tag, meta.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tagKey(tag, id string) []byte {
+	return []byte(tag + "\x00" + id)
+}
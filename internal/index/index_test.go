@@ -0,0 +1,192 @@
+package index
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/models"
+)
+
+func openTestIndex(t *testing.T) (*Index, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "index.db")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx, path
+}
+
+func scanIDs(t *testing.T, idx *Index, prefix, cursor string) []string {
+	t.Helper()
+	var ids []string
+	err := idx.Scan(prefix, cursor, func(meta *models.Metadata) bool {
+		ids = append(ids, meta.ID)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func tagIDs(t *testing.T, idx *Index, tag, cursor string) []string {
+	t.Helper()
+	var ids []string
+	err := idx.ScanTag(tag, cursor, func(meta *models.Metadata) bool {
+		ids = append(ids, meta.ID)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestRoundTrip(t *testing.T) {
+	idx, path := openTestIndex(t)
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	want := &models.Metadata{
+		ID:         "photo",
+		Format:     "webp",
+		Width:      640,
+		Height:     480,
+		Size:       1234,
+		Tags:       []string{"beach", "summer"},
+		AltText:    "A beach",
+		Attributes: map[string]string{"camera": "x100"},
+		Locations:  []string{models.LocationPrimary},
+		CreatedAt:  created,
+		UpdatedAt:  created,
+	}
+	if err := idx.Put(want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := idx.Get("photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 640 || got.AltText != "A beach" || got.Attributes["camera"] != "x100" ||
+		!slices.Equal(got.Tags, want.Tags) || !got.CreatedAt.Equal(created) {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+
+	// Entries survive a reopen
+	idx.Close()
+	if idx, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if got, err := idx.Get("photo"); err != nil || got.Size != 1234 {
+		t.Errorf("Get after reopen = %+v, %v", got, err)
+	}
+	if _, err := idx.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing: err = %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	idx, _ := openTestIndex(t)
+	var entries []*models.Metadata
+	for _, id := range []string{"b2", "a1", "b1", "c1", "a2"} {
+		entries = append(entries, &models.Metadata{ID: id})
+	}
+	if err := idx.PutMany(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix, cursor string
+		want           []string
+	}{
+		{"", "", []string{"a1", "a2", "b1", "b2", "c1"}},
+		{"", "a2", []string{"b1", "b2", "c1"}},
+		{"b", "", []string{"b1", "b2"}},
+		{"b", "a9", []string{"b1", "b2"}},
+		{"b", "b1", []string{"b2"}},
+		{"b", "b2", nil},
+		{"d", "", nil},
+	}
+	for _, tt := range tests {
+		if got := scanIDs(t, idx, tt.prefix, tt.cursor); !slices.Equal(got, tt.want) {
+			t.Errorf("Scan(%q, %q) = %v, want %v", tt.prefix, tt.cursor, got, tt.want)
+		}
+	}
+	if n, err := idx.Count(); err != nil || n != 5 {
+		t.Errorf("Count = %d, %v", n, err)
+	}
+}
+
+func TestTagsFollowWrites(t *testing.T) {
+	idx, _ := openTestIndex(t)
+	err := idx.PutMany([]*models.Metadata{
+		{ID: "a", Tags: []string{"red"}},
+		{ID: "b", Tags: []string{"red", "blue"}},
+		{ID: "c", Tags: []string{"blue"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tagIDs(t, idx, "red", ""); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("red = %v", got)
+	}
+	if got := tagIDs(t, idx, "red", "a"); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("red after a = %v", got)
+	}
+
+	// Update and Put replace the tag entries of the old version
+	if _, err := idx.Update("b", func(m *models.Metadata) error { m.Tags = []string{"green"}; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Put(&models.Metadata{ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	for tag, want := range map[string][]string{"red": nil, "blue": nil, "green": {"b"}} {
+		if got := tagIDs(t, idx, tag, ""); !slices.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", tag, got, want)
+		}
+	}
+
+	// A tag must not match another tag it is a prefix of
+	if err := idx.Put(&models.Metadata{ID: "d", Tags: []string{"greenish"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tagIDs(t, idx, "green", ""); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("green = %v", got)
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	idx, _ := openTestIndex(t)
+	if err := idx.Put(&models.Metadata{ID: "a", AltText: "before", Tags: []string{"keep"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("rejected")
+	_, err := idx.Update("a", func(m *models.Metadata) error {
+		m.AltText = "after"
+		m.Tags = nil
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v", err)
+	}
+	if got, _ := idx.Get("a"); got.AltText != "before" {
+		t.Errorf("AltText = %q after a failed update", got.AltText)
+	}
+	if got := tagIDs(t, idx, "keep", ""); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("keep = %v after a failed update", got)
+	}
+
+	if _, err := idx.Update("missing", func(*models.Metadata) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update missing: err = %v", err)
+	}
+}
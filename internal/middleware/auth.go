@@ -3,16 +3,17 @@ package middleware
 import (
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// DefaultKeyName is the name KeyName reports for API_KEY.
+const DefaultKeyName = "default"
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		expectedKey := os.Getenv("API_KEY")
-
-		if apiKey == "" || apiKey != expectedKey {
+		if !IsAuthenticated(c) {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Unauthorized",
 			})
@@ -23,3 +24,29 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// IsAuthenticated reports whether the request carries the API key. Public
+// routes use it to relax restrictions for trusted clients.
+func IsAuthenticated(c *gin.Context) bool {
+	return KeyName(c) != ""
+}
+
+// KeyName returns the name of the API key the request carries: DefaultKeyName
+// for API_KEY, the configured name for keys in API_KEYS (comma-separated
+// name:key pairs), or "" when the request is not authenticated.
+func KeyName(c *gin.Context) string {
+	apiKey := c.GetHeader("X-API-Key")
+	if apiKey == "" {
+		return ""
+	}
+	if apiKey == os.Getenv("API_KEY") {
+		return DefaultKeyName
+	}
+	for _, pair := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && name != "" && key != "" && apiKey == key {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kartex/imageprovider/pkg/urlsign"
+)
+
+func signedRouter(t *testing.T, scope string) *gin.Engine {
+	t.Helper()
+	t.Setenv("URL_SIGNING_KEYS", "k1:secret")
+	t.Setenv("URL_SIGNING_SCOPE", scope)
+	t.Setenv("API_KEY", "api-key")
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	signed := SignedURLMiddleware()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/images/:id", signed, ok)
+	router.GET("/t/*path", signed, ok)
+	router.GET("/p/:preset/:id", signed, ok)
+	return router
+}
+
+func TestSignedURLScopes(t *testing.T) {
+	key := urlsign.Key{ID: "k1", Secret: []byte("secret")}
+	signURL := func(path string, expires time.Time) string {
+		signed, err := urlsign.Sign(key, path, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	// The query of a URL signed for another image
+	moved := "/images/photo" + strings.TrimPrefix(signURL("/images/other", time.Time{}), "/images/other")
+
+	tests := []struct {
+		scope, url string
+		apiKey     bool
+		want       int
+	}{
+		{"transforms", "/t/rs:300:0/photo", false, http.StatusForbidden},
+		{"transforms", signURL("/t/rs:300:0/photo", time.Time{}), false, http.StatusOK},
+		{"transforms", signURL("/t/rs:300:0/photo", time.Now().Add(-time.Minute)), false, http.StatusGone},
+		{"transforms", "/t/rs:300:0/photo", true, http.StatusOK},
+		{"transforms", "/p/card/photo", false, http.StatusOK},
+		{"transforms", "/images/photo", false, http.StatusOK},
+		{"all", "/p/card/photo", false, http.StatusForbidden},
+		{"all", "/images/photo", false, http.StatusForbidden},
+		{"all", signURL("/p/card/photo", time.Time{}), false, http.StatusOK},
+		{"all", signURL("/images/photo", time.Time{}), false, http.StatusOK},
+		{"all", "/images/photo", true, http.StatusOK},
+		// A signature for one route does not carry over to another
+		{"all", moved, false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		router := signedRouter(t, tt.scope)
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.apiKey {
+			req.Header.Set("X-API-Key", "api-key")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("scope %s, %s (api key %v): status = %d, want %d", tt.scope, tt.url, tt.apiKey, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSignedURLDisabled(t *testing.T) {
+	t.Setenv("URL_SIGNING_KEYS", "")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/t/*path", SignedURLMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/rs:300:0/photo", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
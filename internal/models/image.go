@@ -4,4 +4,6 @@ type Image struct {
 	ID     string
 	Data   []byte
 	Format string
+	Width  int
+	Height int
 }
@@ -0,0 +1,132 @@
+package models
+
+import "time"
+
+// Storage tiers recorded in Metadata.Locations.
+const (
+	LocationPrimary   = "primary"
+	LocationSecondary = "secondary"
+)
+
+type Metadata struct {
+	ID           string            `json:"id"`
+	Format       string            `json:"format"`
+	Width        int               `json:"width,omitempty"`
+	Height       int               `json:"height,omitempty"`
+	Frames       int               `json:"frames,omitempty"`
+	DurationMS   int               `json:"duration_ms,omitempty"`
+	Size         int64             `json:"size"`
+	SHA256       string            `json:"sha256,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	AltText      string            `json:"alt_text,omitempty"`
+	Caption      string            `json:"caption,omitempty"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	EXIF         *EXIF             `json:"exif,omitempty"`
+	ColorProfile string            `json:"color_profile,omitempty"`
+	Placeholder  *Placeholder      `json:"placeholder,omitempty"`
+	PHash        string            `json:"phash,omitempty"`
+	Colors       *Colors           `json:"colors,omitempty"`
+	// NearDuplicates lists the images this one resembled when it was
+	// uploaded with the "flag" duplicate policy.
+	NearDuplicates []string  `json:"near_duplicates,omitempty"`
+	Locations      []string  `json:"locations,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// EXIF holds camera details extracted at ingest. Coordinates are only
+// recorded when the deployment keeps GPS data.
+type EXIF struct {
+	Make         string     `json:"make,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	LensModel    string     `json:"lens_model,omitempty"`
+	TakenAt      *time.Time `json:"taken_at,omitempty"`
+	ExposureTime string     `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+}
+
+// Placeholder holds previews for progressive loading, computed from the
+// stored pixels.
+type Placeholder struct {
+	BlurHash      string `json:"blurhash"`
+	ThumbHash     string `json:"thumbhash"`
+	DominantColor string `json:"dominant_color"`
+}
+
+// Colors describes the main colors of the stored pixels. Animations use
+// their first frame.
+type Colors struct {
+	Palette         []PaletteColor `json:"palette"`
+	Average         string         `json:"average"`
+	Dominant        string         `json:"dominant"`
+	HasTransparency bool           `json:"has_transparency"`
+	Histogram       *Histogram     `json:"histogram,omitempty"`
+}
+
+// Histogram gives the share of opaque pixels in equal-width bins of 0-255
+// per channel, darkest first.
+type Histogram struct {
+	Bins      int       `json:"bins"`
+	Red       []float64 `json:"red"`
+	Green     []float64 `json:"green"`
+	Blue      []float64 `json:"blue"`
+	Luminance []float64 `json:"luminance"`
+}
+
+// PaletteColor is a palette entry with the share of opaque pixels closest
+// to it.
+type PaletteColor struct {
+	Color      string  `json:"color"`
+	Proportion float64 `json:"proportion"`
+}
+
+// KeepUserFields copies the fields that are not derived from pixel data,
+// such as tags and alt text, from a previous version of the metadata.
+func (m *Metadata) KeepUserFields(old *Metadata) {
+	m.Tags = old.Tags
+	m.AltText = old.AltText
+	m.Caption = old.Caption
+	m.Attributes = old.Attributes
+	m.CreatedAt = old.CreatedAt
+}
+
+// KeepIngestFields copies the fields computed from the original upload,
+// which cannot be recomputed from the stored WebP.
+func (m *Metadata) KeepIngestFields(old *Metadata) {
+	m.Format = old.Format
+	m.EXIF = old.EXIF
+	m.ColorProfile = old.ColorProfile
+	m.NearDuplicates = old.NearDuplicates
+}
+
+// HasTag reports whether the image carries the tag.
+func (m *Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// HasLocation reports whether the image is known to exist in the given tier.
+func (m *Metadata) HasLocation(location string) bool {
+	for _, l := range m.Locations {
+		if l == location {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLocation records the tier if it is not already present.
+func (m *Metadata) AddLocation(location string) {
+	if !m.HasLocation(location) {
+		m.Locations = append(m.Locations, location)
+	}
+}
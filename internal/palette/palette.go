@@ -0,0 +1,264 @@
+// Package palette extracts the main colors of an image: a palette with the
+// share of the image each color covers, the average color, a coarse
+// histogram and whether the image has transparent pixels.
+package palette
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	sampleSize = 128 // colors are taken from a copy fitting sampleSize x sampleSize
+	iterations = 10  // k-means refinement passes after the median cut
+
+	// HistogramBins is the number of equal-width bins per channel.
+	HistogramBins = 16
+)
+
+// Color is one palette entry.
+type Color struct {
+	Hex        string  // #rrggbb
+	Proportion float64 // share of the opaque pixels closest to this color
+}
+
+// Result holds the color analysis of one image.
+type Result struct {
+	Palette         []Color // largest share first
+	Average         string
+	Dominant        string
+	HasTransparency bool
+	Histogram       Histogram
+}
+
+// Histogram holds, for each channel, the share of opaque pixels in each of
+// HistogramBins equal ranges of 0-255. Luminance uses the Rec. 709 weights.
+type Histogram struct {
+	Red, Green, Blue, Luminance [HistogramBins]float64
+}
+
+type pixel [3]float64
+
+// Analyze extracts up to size palette colors from img. The palette starts
+// from a median cut and is refined with k-means; fully transparent images get
+// an empty palette and black average and dominant colors.
+func Analyze(img image.Image, size int) *Result {
+	sample := downscale(img, sampleSize)
+
+	var pixels []pixel
+	var sum pixel
+	var weight float64
+	for i := 0; i < len(sample.Pix); i += 4 {
+		p := pixel{float64(sample.Pix[i]), float64(sample.Pix[i+1]), float64(sample.Pix[i+2])}
+		a := float64(sample.Pix[i+3]) / 255
+		for c := range sum {
+			sum[c] += p[c] * a
+		}
+		weight += a
+		if a >= 0.5 {
+			pixels = append(pixels, p)
+		}
+	}
+
+	r := &Result{
+		Average:         "#000000",
+		Dominant:        "#000000",
+		HasTransparency: !isOpaque(img),
+	}
+	if weight > 0 {
+		r.Average = hex(pixel{sum[0] / weight, sum[1] / weight, sum[2] / weight})
+	}
+	if len(pixels) == 0 {
+		return r
+	}
+	r.Histogram = histogram(pixels)
+	if size <= 0 {
+		return r
+	}
+
+	centers := kmeans(pixels, medianCut(pixels, size))
+	counts := make([]int, len(centers))
+	for _, p := range pixels {
+		counts[nearest(centers, p)]++
+	}
+
+	for i, c := range centers {
+		if counts[i] == 0 {
+			continue
+		}
+		share := float64(counts[i]) / float64(len(pixels))
+		r.Palette = append(r.Palette, Color{Hex: hex(c), Proportion: math.Round(share*10000) / 10000})
+	}
+	sort.SliceStable(r.Palette, func(i, j int) bool {
+		return r.Palette[i].Proportion > r.Palette[j].Proportion
+	})
+	r.Dominant = r.Palette[0].Hex
+	return r
+}
+
+func histogram(pixels []pixel) Histogram {
+	var h Histogram
+	bin := func(v float64) int { return min(int(v)*HistogramBins/256, HistogramBins-1) }
+	for _, p := range pixels {
+		h.Red[bin(p[0])]++
+		h.Green[bin(p[1])]++
+		h.Blue[bin(p[2])]++
+		h.Luminance[bin(0.2126*p[0]+0.7152*p[1]+0.0722*p[2])]++
+	}
+	for _, channel := range []*[HistogramBins]float64{&h.Red, &h.Green, &h.Blue, &h.Luminance} {
+		for i := range channel {
+			channel[i] = math.Round(channel[i]/float64(len(pixels))*10000) / 10000
+		}
+	}
+	return h
+}
+
+// medianCut splits the pixels into up to size boxes, always splitting the
+// box with the widest channel range at its median, and returns their means.
+func medianCut(pixels []pixel, size int) []pixel {
+	boxes := [][]pixel{append([]pixel(nil), pixels...)}
+	for len(boxes) < size {
+		best, channel, widest := -1, 0, 0.0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, spread := widestChannel(box); spread > widest {
+				best, channel, widest = i, c, spread
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][channel] < box[j][channel] })
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	centers := make([]pixel, len(boxes))
+	for i, box := range boxes {
+		centers[i] = mean(box)
+	}
+	return centers
+}
+
+func widestChannel(box []pixel) (int, float64) {
+	lo, hi := box[0], box[0]
+	for _, p := range box[1:] {
+		for c := range p {
+			lo[c] = math.Min(lo[c], p[c])
+			hi[c] = math.Max(hi[c], p[c])
+		}
+	}
+	channel := 0
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[channel]-lo[channel] {
+			channel = c
+		}
+	}
+	return channel, hi[channel] - lo[channel]
+}
+
+// kmeans moves each center to the mean of the pixels nearest to it until
+// the assignment settles.
+func kmeans(pixels []pixel, centers []pixel) []pixel {
+	for range iterations {
+		sums := make([]pixel, len(centers))
+		counts := make([]int, len(centers))
+		for _, p := range pixels {
+			i := nearest(centers, p)
+			for c := range p {
+				sums[i][c] += p[c]
+			}
+			counts[i]++
+		}
+
+		moved := false
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			next := pixel{sums[i][0] / float64(counts[i]), sums[i][1] / float64(counts[i]), sums[i][2] / float64(counts[i])}
+			if distance(next, centers[i]) > 0.25 {
+				moved = true
+			}
+			centers[i] = next
+		}
+		if !moved {
+			break
+		}
+	}
+	return centers
+}
+
+func nearest(centers []pixel, p pixel) int {
+	best, bestDist := 0, math.MaxFloat64
+	for i, c := range centers {
+		if d := distance(c, p); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func distance(a, b pixel) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+func mean(box []pixel) pixel {
+	var m pixel
+	for _, p := range box {
+		for c := range p {
+			m[c] += p[c]
+		}
+	}
+	for c := range m {
+		m[c] /= float64(len(box))
+	}
+	return m
+}
+
+func hex(p pixel) string {
+	return fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(p[0])), uint8(math.Round(p[1])), uint8(math.Round(p[2])))
+}
+
+// isOpaque checks the full image, since downscaling can average away a few
+// transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// downscale returns an NRGBA copy of img that fits in size x size.
+func downscale(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(h*size/w, 1)
+		} else {
+			w, h = max(w*size/h, 1), size
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
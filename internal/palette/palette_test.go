@@ -0,0 +1,51 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	// Left half red, right half black
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{A: 255}
+			if x < 32 {
+				c.R = 255
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	h := Analyze(img, 2).Histogram
+	for name, channel := range map[string][HistogramBins]float64{"red": h.Red, "green": h.Green, "blue": h.Blue, "luminance": h.Luminance} {
+		var sum float64
+		for _, v := range channel {
+			sum += v
+		}
+		if math.Abs(sum-1) > 0.001 {
+			t.Errorf("%s shares sum to %v, want 1", name, sum)
+		}
+	}
+	// Resampling blends the boundary column, so allow a little spread
+	if h.Red[0] < 0.45 || h.Red[HistogramBins-1] < 0.45 {
+		t.Errorf("red = %v, want about half in the first and last bins", h.Red)
+	}
+	if h.Green[0] != 1 || h.Blue[0] != 1 {
+		t.Errorf("green = %v, blue = %v, want everything in the first bin", h.Green, h.Blue)
+	}
+	// Pure red has a luminance of 0.2126 * 255, in bin 3
+	if h.Luminance[0] < 0.45 || h.Luminance[3] < 0.45 {
+		t.Errorf("luminance = %v", h.Luminance)
+	}
+}
+
+func TestHistogramTransparent(t *testing.T) {
+	r := Analyze(image.NewNRGBA(image.Rect(0, 0, 8, 8)), 5)
+	if len(r.Palette) != 0 || r.Histogram != (Histogram{}) || !r.HasTransparency {
+		t.Errorf("transparent image: %+v", r)
+	}
+}
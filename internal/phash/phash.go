@@ -0,0 +1,95 @@
+// Package phash computes perceptual hashes that stay close for resized,
+// re-compressed or slightly edited copies of an image.
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	sampleSize = 32 // the image is reduced to sampleSize x sampleSize
+	hashSize   = 8  // the lowest hashSize x hashSize frequencies form the hash
+)
+
+// Hash is a 64-bit perceptual hash.
+type Hash uint64
+
+// Compute returns the DCT-based pHash of img: the lowest 8x8 frequencies of
+// a 32x32 grayscale copy, each bit set when the coefficient is above their
+// median.
+func Compute(img image.Image) Hash {
+	small := image.NewRGBA(image.Rect(0, 0, sampleSize, sampleSize))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var pixels [sampleSize][sampleSize]float64
+	for y := 0; y < sampleSize; y++ {
+		for x := 0; x < sampleSize; x++ {
+			i := small.PixOffset(x, y)
+			p := small.Pix[i : i+3]
+			pixels[y][x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+
+	// Separable 2D DCT-II, rows then the low-frequency columns
+	var rows [sampleSize][hashSize]float64
+	for y := 0; y < sampleSize; y++ {
+		for u := 0; u < hashSize; u++ {
+			rows[y][u] = dct(func(x int) float64 { return pixels[y][x] }, u)
+		}
+	}
+	coeffs := make([]float64, 0, hashSize*hashSize)
+	for v := 0; v < hashSize; v++ {
+		for u := 0; u < hashSize; u++ {
+			coeffs = append(coeffs, dct(func(y int) float64 { return rows[y][u] }, v))
+		}
+	}
+
+	sorted := append([]float64(nil), coeffs...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h Hash
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << uint(len(coeffs)-1-i)
+		}
+	}
+	return h
+}
+
+func dct(at func(int) float64, k int) float64 {
+	var sum float64
+	for n := 0; n < sampleSize; n++ {
+		sum += at(n) * math.Cos(math.Pi/sampleSize*(float64(n)+0.5)*float64(k))
+	}
+	return sum
+}
+
+// Distance is the number of differing bits, 0 for identical hashes and at
+// most 64.
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Parse reads a hash formatted by String.
+func Parse(s string) (Hash, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid perceptual hash %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q", s)
+	}
+	return Hash(v), nil
+}
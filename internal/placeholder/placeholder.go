@@ -0,0 +1,85 @@
+// Package placeholder computes compact previews shown while an image loads:
+// a BlurHash, a ThumbHash and the dominant color.
+package placeholder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// sampleSize bounds the downscaled copy the hashes are computed from.
+// ThumbHash requires at most 100x100.
+const sampleSize = 64
+
+// Result holds the placeholders for one image.
+type Result struct {
+	BlurHash      string
+	ThumbHash     string // base64
+	DominantColor string // #rrggbb
+}
+
+// Compute derives all placeholders from img.
+func Compute(img image.Image) *Result {
+	sample := downscale(img, sampleSize)
+	return &Result{
+		BlurHash:      BlurHash(sample),
+		ThumbHash:     base64.StdEncoding.EncodeToString(ThumbHash(sample)),
+		DominantColor: DominantColor(sample),
+	}
+}
+
+// downscale returns an NRGBA copy of img that fits in size x size.
+func downscale(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(h*size/w, 1)
+		} else {
+			w, h = max(w*size/h, 1), size
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// DominantColor returns the most common color of img, bucketed to 4 bits per
+// channel and averaged within the bucket. Mostly transparent pixels are
+// ignored.
+func DominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	var best *bucket
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b, a := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2]), img.Pix[i+3]
+		if a < 128 {
+			continue
+		}
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+		if best == nil || bk.count > best.count {
+			best = bk
+		}
+	}
+
+	if best == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
@@ -0,0 +1,125 @@
+package placeholder
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func filled(w, h int, at func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, at(x, y))
+		}
+	}
+	return img
+}
+
+func uniform(w, h int, c color.NRGBA) *image.NRGBA {
+	return filled(w, h, func(int, int) color.NRGBA { return c })
+}
+
+func decode83(s string) int {
+	v := 0
+	for _, c := range s {
+		v = v*83 + strings.IndexRune(base83Chars, c)
+	}
+	return v
+}
+
+// acRed returns the quantized red value of AC component i, where 9 is zero.
+func acRed(hash string, i int) int {
+	return decode83(hash[6+i*2:8+i*2]) / (19 * 19)
+}
+
+func TestBlurHash(t *testing.T) {
+	hash := BlurHash(uniform(32, 16, color.NRGBA{200, 100, 50, 255}))
+	if len(hash) != 28 || hash[0] != 'L' {
+		t.Fatalf("hash = %q, want 4x3 components", hash)
+	}
+	if dc := decode83(hash[2:6]); dc != 200<<16|100<<8|50 {
+		t.Errorf("DC = %06x, want c86432", dc)
+	}
+
+	// Portrait images use 3x4 components
+	if hash := BlurHash(uniform(16, 32, color.NRGBA{A: 255})); len(hash) != 28 || hash[0] != 'T' {
+		t.Errorf("portrait hash = %q", hash)
+	}
+
+	// A white left half and black right half: the strongest positive (1,0)
+	// term, a weaker (0,1) term from sampling at pixel corners as the
+	// reference encoder does, and the average color as DC
+	split := filled(32, 16, func(x, _ int) color.NRGBA {
+		if x < 16 {
+			return color.NRGBA{255, 255, 255, 255}
+		}
+		return color.NRGBA{A: 255}
+	})
+	hash = BlurHash(split)
+	if h, v := acRed(hash, 0), acRed(hash, 3); h != 18 || v >= h {
+		t.Errorf("hash = %q: horizontal %d, vertical %d", hash, h, v)
+	}
+	if dc := decode83(hash[2:6]); dc>>16 < 180 || dc>>16 > 195 {
+		t.Errorf("DC = %06x, want the sRGB value of 50%% linear light", dc)
+	}
+}
+
+func TestThumbHash(t *testing.T) {
+	header := func(hash []byte) int { return int(hash[0]) | int(hash[1])<<8 | int(hash[2])<<16 }
+
+	white := ThumbHash(uniform(32, 16, color.NRGBA{255, 255, 255, 255}))
+	black := ThumbHash(uniform(32, 16, color.NRGBA{A: 255}))
+	if l := header(white) & 63; l != 63 {
+		t.Errorf("white luminance = %d, want 63", l)
+	}
+	if l := header(black) & 63; l != 0 {
+		t.Errorf("black luminance = %d, want 0", l)
+	}
+	if header(white)&(1<<23) != 0 || white[4]&0x80 == 0 {
+		t.Errorf("white header = %x, want opaque landscape", white[:5])
+	}
+
+	// Transparency adds the alpha byte and AC terms
+	half := filled(32, 32, func(x, _ int) color.NRGBA {
+		if x < 16 {
+			return color.NRGBA{255, 0, 0, 255}
+		}
+		return color.NRGBA{}
+	})
+	hash := ThumbHash(half)
+	if header(hash)&(1<<23) == 0 || hash[4]&0x80 != 0 {
+		t.Errorf("header = %x, want alpha and portrait orientation", hash[:5])
+	}
+	if len(hash) <= len(white) {
+		t.Errorf("hash with alpha is %d bytes, opaque one %d", len(hash), len(white))
+	}
+}
+
+func TestCompute(t *testing.T) {
+	// Mostly red with a blue stripe and a transparent corner
+	img := filled(400, 200, func(x, y int) color.NRGBA {
+		switch {
+		case x < 100 && y < 100:
+			return color.NRGBA{B: 255, A: 10}
+		case x > 350:
+			return color.NRGBA{B: 255, A: 255}
+		}
+		return color.NRGBA{R: 250, A: 255}
+	})
+	r := Compute(img)
+	if r.DominantColor != "#fa0000" {
+		t.Errorf("dominant color = %s", r.DominantColor)
+	}
+	if len(r.BlurHash) != 28 {
+		t.Errorf("BlurHash = %q", r.BlurHash)
+	}
+	if _, err := base64.StdEncoding.DecodeString(r.ThumbHash); err != nil {
+		t.Errorf("ThumbHash = %q: %v", r.ThumbHash, err)
+	}
+	if sample := downscale(img, sampleSize); sample.Bounds() != image.Rect(0, 0, 64, 32) {
+		t.Errorf("sample = %v", sample.Bounds())
+	}
+}
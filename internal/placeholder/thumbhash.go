@@ -0,0 +1,133 @@
+package placeholder
+
+import (
+	"image"
+	"math"
+)
+
+// ThumbHash encodes img, which must fit in 100x100. It follows the reference
+// encoder at https://github.com/evanw/thumbhash.
+func ThumbHash(img *image.NRGBA) []byte {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	n := w * h
+
+	// Average color, weighted by alpha
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < n; i++ {
+		p := img.Pix[i*4:]
+		alpha := float64(p[3]) / 255
+		avgR += alpha / 255 * float64(p[0])
+		avgG += alpha / 255 * float64(p[1])
+		avgB += alpha / 255 * float64(p[2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0
+	if hasAlpha {
+		lLimit = 5 // fewer luminance bits leave room for alpha
+	}
+	longest := float64(max(w, h))
+	lx := max(1, int(round(lLimit*float64(w)/longest)))
+	ly := max(1, int(round(lLimit*float64(h)/longest)))
+
+	// Convert to LPQA, composited over the average color
+	l := make([]float64, n)
+	p := make([]float64, n)
+	q := make([]float64, n)
+	a := make([]float64, n)
+	for i := 0; i < n; i++ {
+		px := img.Pix[i*4:]
+		alpha := float64(px[3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(px[0])
+		g := avgG*(1-alpha) + alpha/255*float64(px[1])
+		b := avgB*(1-alpha) + alpha/255*float64(px[2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	encodeChannel := func(channel []float64, nx, ny int) (dc float64, ac []float64, scale float64) {
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				var f float64
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+	var aDC, aScale float64
+	var aAC []float64
+	if hasAlpha {
+		aDC, aAC, aScale = encodeChannel(a, 5, 5)
+	}
+
+	isLandscape := w > h
+	header24 := int(round(63*lDC)) | int(round(31.5+31.5*pDC))<<6 | int(round(31.5+31.5*qDC))<<12 | int(round(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := int(round(63*pScale))<<3 | int(round(63*qScale))<<9
+	if isLandscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	acs := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		hash = append(hash, byte(int(round(15*aDC))|int(round(15*aScale))<<4))
+		acs = append(acs, aAC)
+	}
+
+	// Pack the AC terms as 4-bit nibbles, low nibble first
+	start, index := len(hash), 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			pos := start + index>>1
+			if pos == len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[pos] |= byte(int(round(15*f)) << ((index & 1) << 2))
+			index++
+		}
+	}
+	return hash
+}
+
+// round matches JavaScript's Math.round, which the reference encoder uses.
+func round(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
@@ -0,0 +1,252 @@
+// Package pool runs CPU-heavy image work on a fixed number of workers with
+// bounded, prioritised queues.
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Priority selects the queue a job waits in.
+type Priority int
+
+const (
+	// Interactive work has a client waiting on it, e.g. rendering a variant.
+	Interactive Priority = iota
+	// Bulk work is throughput-oriented, e.g. ingesting uploads.
+	Bulk
+)
+
+const maxRetryAfter = time.Minute
+
+var (
+	ErrQueueFull = errors.New("processing queue is full")
+	ErrPanic     = errors.New("processing job panicked")
+)
+
+// QueueFullError is returned when a job is rejected because its lane is at
+// capacity. It matches ErrQueueFull with errors.Is.
+type QueueFullError struct {
+	Lane       string
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("%s processing queue is full, retry in %s", e.Lane, e.RetryAfter)
+}
+
+func (e *QueueFullError) Is(target error) bool {
+	return target == ErrQueueFull
+}
+
+// PanicError is returned when a job panics. The worker logs the stack; the
+// error matches ErrPanic with errors.Is.
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPanic, e.Value)
+}
+
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
+func (p Priority) String() string {
+	if p == Bulk {
+		return "bulk"
+	}
+	return "interactive"
+}
+
+type job struct {
+	fn       func()
+	enqueued time.Time
+	done     chan error // receives a *PanicError, or nil
+}
+
+// Pool executes jobs on a fixed set of workers. Every worker serves the
+// interactive lane, but only bulkWorkers of them take bulk jobs, so a burst
+// of uploads can never occupy the whole pool.
+type Pool struct {
+	lanes       [2]chan *job
+	workers     int
+	bulkWorkers int
+	stats       [2]laneStats
+}
+
+type laneStats struct {
+	mu        sync.Mutex
+	running   int
+	completed int64
+	rejected  int64
+	waitTotal time.Duration
+	waitMax   time.Duration
+	runTotal  time.Duration
+}
+
+// New starts workers goroutines. queueSize bounds each lane; bulkWorkers is
+// clamped to 1..workers.
+func New(workers, bulkWorkers, queueSize int) *Pool {
+	workers = max(workers, 1)
+	bulkWorkers = min(max(bulkWorkers, 1), workers)
+	p := &Pool{workers: workers, bulkWorkers: bulkWorkers}
+	for i := range p.lanes {
+		p.lanes[i] = make(chan *job, max(queueSize, 0))
+	}
+	for i := 0; i < workers; i++ {
+		go p.work(i < bulkWorkers)
+	}
+	return p
+}
+
+// Do runs fn on the pool and waits for it to finish. When the lane is full it
+// returns a *QueueFullError immediately. A panic in fn is recovered and
+// returned as a *PanicError, so callers on background goroutines cannot
+// crash the process.
+func (p *Pool) Do(priority Priority, fn func()) error {
+	j := &job{fn: fn, enqueued: time.Now(), done: make(chan error, 1)}
+	select {
+	case p.lanes[priority] <- j:
+	default:
+		s := &p.stats[priority]
+		s.mu.Lock()
+		s.rejected++
+		s.mu.Unlock()
+		return &QueueFullError{Lane: priority.String(), RetryAfter: p.retryAfter(priority)}
+	}
+
+	return <-j.done
+}
+
+func (p *Pool) work(bulk bool) {
+	interactive := p.lanes[Interactive]
+	var bulkLane chan *job
+	if bulk {
+		bulkLane = p.lanes[Bulk]
+	}
+
+	for {
+		// Drain interactive work first; a nil bulk lane is never selected
+		select {
+		case j := <-interactive:
+			p.run(Interactive, j)
+			continue
+		default:
+		}
+		select {
+		case j := <-interactive:
+			p.run(Interactive, j)
+		case j := <-bulkLane:
+			p.run(Bulk, j)
+		}
+	}
+}
+
+func (p *Pool) run(priority Priority, j *job) {
+	s := &p.stats[priority]
+	start := time.Now()
+	wait := start.Sub(j.enqueued)
+	s.mu.Lock()
+	s.running++
+	s.waitTotal += wait
+	s.waitMax = max(s.waitMax, wait)
+	s.mu.Unlock()
+
+	var recovered error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Warning: Recovered from panic in %s job: %v\n%s", priority, r, debug.Stack())
+				recovered = &PanicError{Value: r}
+			}
+		}()
+		j.fn()
+	}()
+
+	s.mu.Lock()
+	s.running--
+	s.completed++
+	s.runTotal += time.Since(start)
+	s.mu.Unlock()
+	j.done <- recovered
+}
+
+// retryAfter estimates how long the lane needs to drain, from its length and
+// the average run time so far.
+func (p *Pool) retryAfter(priority Priority) time.Duration {
+	s := &p.stats[priority]
+	s.mu.Lock()
+	avg := time.Second
+	if s.completed > 0 {
+		avg = s.runTotal / time.Duration(s.completed)
+	}
+	s.mu.Unlock()
+
+	workers := p.workers
+	if priority == Bulk {
+		workers = p.bulkWorkers
+	}
+	estimate := time.Duration(len(p.lanes[priority])) * avg / time.Duration(workers)
+	seconds := math.Ceil(estimate.Seconds())
+	return min(max(time.Duration(seconds)*time.Second, time.Second), maxRetryAfter)
+}
+
+// LaneStats describes one priority lane. Durations are in milliseconds.
+type LaneStats struct {
+	Queued    int     `json:"queued"`
+	Capacity  int     `json:"capacity"`
+	Running   int     `json:"running"`
+	Completed int64   `json:"completed"`
+	Rejected  int64   `json:"rejected"`
+	AvgWaitMS float64 `json:"avg_wait_ms"`
+	MaxWaitMS float64 `json:"max_wait_ms"`
+	AvgRunMS  float64 `json:"avg_run_ms"`
+}
+
+// Stats is a snapshot of the pool's configuration and queues.
+type Stats struct {
+	Workers     int       `json:"workers"`
+	BulkWorkers int       `json:"bulk_workers"`
+	Interactive LaneStats `json:"interactive"`
+	Bulk        LaneStats `json:"bulk"`
+}
+
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Workers:     p.workers,
+		BulkWorkers: p.bulkWorkers,
+		Interactive: p.laneStats(Interactive),
+		Bulk:        p.laneStats(Bulk),
+	}
+}
+
+func (p *Pool) laneStats(priority Priority) LaneStats {
+	s := &p.stats[priority]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ls := LaneStats{
+		Queued:    len(p.lanes[priority]),
+		Capacity:  cap(p.lanes[priority]),
+		Running:   s.running,
+		Completed: s.completed,
+		Rejected:  s.rejected,
+		MaxWaitMS: milliseconds(s.waitMax),
+	}
+	if s.completed > 0 {
+		ls.AvgWaitMS = milliseconds(s.waitTotal / time.Duration(s.completed))
+		ls.AvgRunMS = milliseconds(s.runTotal / time.Duration(s.completed))
+	}
+	return ls
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
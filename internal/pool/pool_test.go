@@ -0,0 +1,27 @@
+package pool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDoRecoversPanic(t *testing.T) {
+	p := New(1, 1, 1)
+
+	for _, priority := range []Priority{Interactive, Bulk} {
+		err := p.Do(priority, func() { panic("boom") })
+		var perr *PanicError
+		if !errors.As(err, &perr) || perr.Value != "boom" || !errors.Is(err, ErrPanic) {
+			t.Fatalf("%s: error = %v, want a PanicError", priority, err)
+		}
+	}
+
+	// The worker survives and keeps serving jobs
+	ran := false
+	if err := p.Do(Interactive, func() { ran = true }); err != nil || !ran {
+		t.Fatalf("Do after panic: ran = %v, err = %v", ran, err)
+	}
+	if s := p.Stats(); s.Interactive.Completed != 2 || s.Bulk.Completed != 1 {
+		t.Fatalf("completed = %d/%d, want 2/1", s.Interactive.Completed, s.Bulk.Completed)
+	}
+}
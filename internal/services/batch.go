@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// UploadFile is a single file submitted as part of a batch upload.
+type UploadFile struct {
+	Name string
+	Data []byte
+	Err  error
+}
+
+// UploadResult reports the outcome of one file in a batch upload.
+type UploadResult struct {
+	Name   string `json:"name"`
+	ID     string `json:"id,omitempty"`
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	NearDuplicates []string    `json:"near_duplicates,omitempty"`
+	Violations     []Violation `json:"violations,omitempty"`
+}
+
+// IngestBatch ingests files concurrently, bounded by BATCH_CONCURRENCY.
+// Results are returned in the same order as files; a failure in one file
+// does not affect the others. Files whose names map to an ID already used
+// in the batch are reported as failed.
+func (s *ImageService) IngestBatch(files []UploadFile, opts UploadOptions) []UploadResult {
+	concurrency := runtime.NumCPU()
+	if v := os.Getenv("BATCH_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			concurrency = n
+		}
+	}
+
+	results := make([]UploadResult, len(files))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	// Entries such as a/logo.png and b/logo.png map to the same ID; only the
+	// first is stored so one does not silently replace the other
+	claimed := map[string]string{}
+
+	for i, file := range files {
+		results[i].Name = file.Name
+		if file.Err != nil {
+			results[i].Error = file.Err.Error()
+			continue
+		}
+		id := imageID(file.Name)
+		if first, ok := claimed[id]; ok {
+			results[i].Error = fmt.Sprintf("duplicate image ID %q, already used by %s", id, first)
+			continue
+		}
+		claimed[id] = file.Name
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, file UploadFile) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			meta, err := s.Ingest(file.Name, file.Data, opts)
+			if err != nil {
+				results[i].Error = err.Error()
+				var violation *PolicyError
+				if errors.As(err, &violation) {
+					results[i].Violations = violation.Violations
+				}
+				return
+			}
+			results[i].ID = meta.ID
+			results[i].Format = meta.Format
+			results[i].Width = meta.Width
+			results[i].Height = meta.Height
+			results[i].NearDuplicates = meta.NearDuplicates
+		}(i, file)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"image"
+
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/palette"
+)
+
+const (
+	defaultPaletteSize = 5
+	maxPaletteSize     = 16
+)
+
+var ErrColorsMissing = errors.New("colors have not been computed for this image")
+
+func (s *ImageService) computeColors(img image.Image) *models.Colors {
+	r := palette.Analyze(img, s.ingest.paletteSize)
+	colors := &models.Colors{
+		Palette:         make([]models.PaletteColor, len(r.Palette)),
+		Average:         r.Average,
+		Dominant:        r.Dominant,
+		HasTransparency: r.HasTransparency,
+	}
+	for i, c := range r.Palette {
+		colors.Palette[i] = models.PaletteColor{Color: c.Hex, Proportion: c.Proportion}
+	}
+	if len(colors.Palette) > 0 {
+		h := r.Histogram
+		colors.Histogram = &models.Histogram{
+			Bins:      palette.HistogramBins,
+			Red:       h.Red[:],
+			Green:     h.Green[:],
+			Blue:      h.Blue[:],
+			Luminance: h.Luminance[:],
+		}
+	}
+	return colors
+}
+
+// GetColors returns the color analysis recorded at ingest or by
+// imagectl reindex. Colors are never computed on request, so this is a
+// metadata read and requires the index.
+func (s *ImageService) GetColors(id string) (*models.Colors, error) {
+	if s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+	meta, err := s.GetInfo(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if meta.Colors == nil {
+		return nil, ErrColorsMissing
+	}
+	return meta.Colors, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/pool"
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+const eagerRetries = 3
+
+// loadEagerPresets reads EAGER_PRESETS, a comma-separated list of presets
+// rendered after every upload. Unknown names are dropped with a warning.
+func loadEagerPresets(presets transform.Presets) []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("EAGER_PRESETS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := presets.Get(name); !ok {
+			log.Printf("Warning: Ignoring unknown preset %q in EAGER_PRESETS", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// EagerPresets returns the presets rendered after every upload.
+func (s *ImageService) EagerPresets() []string {
+	return s.eagerPresets
+}
+
+// Pregenerate renders variants of a new image in the background on the bulk
+// lane, so the first request for them is served from the variant cache. When
+// the queue is full it waits for the suggested delay a few times before
+// giving up.
+func (s *ImageService) Pregenerate(id string, pipelines []*transform.Pipeline) {
+	if len(pipelines) == 0 {
+		return
+	}
+	go func() {
+		for _, pipeline := range pipelines {
+			for attempt := 0; ; attempt++ {
+				_, err := s.transformOn(pool.Bulk, id, pipeline)
+				var full *pool.QueueFullError
+				if errors.As(err, &full) && attempt < eagerRetries {
+					time.Sleep(full.RetryAfter)
+					continue
+				}
+				if err != nil {
+					log.Printf("Warning: Failed to pre-generate %s/%s: %v", id, pipeline.Canonical(), err)
+				}
+				break
+			}
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+func TestLoadEagerPresets(t *testing.T) {
+	presets, err := transform.ParsePresets("thumb=rs:fill:10:10,hero=rs:100:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EAGER_PRESETS", " Thumb, missing,,hero")
+	if got := loadEagerPresets(presets); !reflect.DeepEqual(got, []string{"thumb", "hero"}) {
+		t.Errorf("presets = %v", got)
+	}
+}
+
+func TestPregenerate(t *testing.T) {
+	s, primary := newTransformService(t)
+	if err := primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+	var pipelines []*transform.Pipeline
+	for _, chain := range []string{"f:png", "rs:fit:2:2/f:png"} {
+		pipeline, err := transform.Parse(chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+
+	s.Pregenerate("pic", pipelines)
+	for _, pipeline := range pipelines {
+		key := "pic/" + pipeline.Canonical()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, ok := s.variants.Get(key); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not pre-generated", key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
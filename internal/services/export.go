@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/archive"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+// ExportQuery selects the images to export. IDs takes precedence over Tag,
+// which takes precedence over Prefix. The modification range further narrows
+// any of them.
+type ExportQuery struct {
+	IDs            []string
+	Tag            string
+	Prefix         string
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+}
+
+// ValidExportID reports whether id can name an archive entry and a storage
+// path without escaping either.
+func ValidExportID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && !strings.Contains(id, "..")
+}
+
+// ManifestEntry describes one exported image in the archive manifest.
+type ManifestEntry struct {
+	ID     string `json:"id"`
+	File   string `json:"file,omitempty"`
+	Format string `json:"format,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Source string `json:"source,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Metadata is the index entry, including tags and alt text, when available
+	Metadata *models.Metadata `json:"metadata,omitempty"`
+}
+
+// Manifest is written as the last entry of every export archive.
+type Manifest struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	Count      int             `json:"count"`
+	Images     []ManifestEntry `json:"images"`
+}
+
+// Export streams the selected images from storage into a zip or tar archive.
+// Images are read one at a time so only a single image is held in memory.
+func (s *ImageService) Export(w io.Writer, format string, q ExportQuery) error {
+	aw, err := archive.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	ids := q.IDs
+	switch {
+	case len(ids) > 0:
+	case q.Tag != "":
+		ids, err = s.listTaggedIDs(q.Tag)
+	default:
+		ids, err = s.listStoredIDs(q.Prefix)
+	}
+	if err != nil {
+		return err
+	}
+	if !q.ModifiedAfter.IsZero() || !q.ModifiedBefore.IsZero() {
+		if ids, err = s.filterModified(ids, q); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	manifest := Manifest{ExportedAt: now, Images: make([]ManifestEntry, 0, len(ids))}
+
+	for _, id := range ids {
+		entry := ManifestEntry{ID: id}
+		if !ValidExportID(id) {
+			entry.Error = "invalid image ID"
+			manifest.Images = append(manifest.Images, entry)
+			continue
+		}
+
+		img, source, err := s.loadStored(id)
+		if err != nil {
+			entry.Error = "image not found"
+			manifest.Images = append(manifest.Images, entry)
+			continue
+		}
+
+		sum := sha256.Sum256(img.Data)
+		entry.File = "images/" + id + ".webp"
+		entry.Format = img.Format
+		entry.Size = len(img.Data)
+		entry.SHA256 = hex.EncodeToString(sum[:])
+		entry.Source = source
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err == nil {
+			entry.Width = cfg.Width
+			entry.Height = cfg.Height
+		}
+
+		if s.index != nil {
+			if meta, err := s.index.Get(id); err == nil {
+				entry.Metadata = meta
+			}
+		}
+
+		if err := aw.WriteFile(entry.File, img.Data, now); err != nil {
+			return err
+		}
+		manifest.Images = append(manifest.Images, entry)
+		manifest.Count++
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := aw.WriteFile(archive.ManifestName, data, now); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// filterModified keeps the IDs last modified within the query's range. Times
+// come from the index when available, otherwise from the storage listings.
+func (s *ImageService) filterModified(ids []string, q ExportQuery) ([]string, error) {
+	modified, err := s.modTimes(q.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, id := range ids {
+		t, ok := modified[id]
+		if !ok {
+			continue
+		}
+		if !q.ModifiedAfter.IsZero() && t.Before(q.ModifiedAfter) {
+			continue
+		}
+		if !q.ModifiedBefore.IsZero() && t.After(q.ModifiedBefore) {
+			continue
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+func (s *ImageService) modTimes(prefix string) (map[string]time.Time, error) {
+	modified := make(map[string]time.Time)
+	if s.index != nil {
+		err := s.index.Scan(prefix, "", func(meta *models.Metadata) bool {
+			modified[meta.ID] = meta.UpdatedAt
+			return true
+		})
+		return modified, err
+	}
+
+	for _, store := range []storage.Storage{s.primary, s.secondary} {
+		if store == nil {
+			continue
+		}
+		cursor := ""
+		for {
+			page, err := store.ListPage(storage.ListOptions{Prefix: prefix, Cursor: cursor, Limit: storage.MaxPageSize})
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range page.Objects {
+				if _, ok := modified[obj.ID]; !ok {
+					modified[obj.ID] = obj.ModTime
+				}
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+	}
+	return modified, nil
+}
+
+// loadStored reads an image directly from storage, bypassing the cache.
+func (s *ImageService) loadStored(id string) (*models.Image, string, error) {
+	img, err := s.primary.Get(id)
+	if err == nil {
+		return img, "primary", nil
+	}
+	if s.secondary != nil {
+		if img, serr := s.secondary.Get(id); serr == nil {
+			return img, "secondary", nil
+		}
+	}
+	return nil, "", err
+}
+
+// listTaggedIDs returns the IDs of indexed images carrying the tag.
+func (s *ImageService) listTaggedIDs(tag string) ([]string, error) {
+	if s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+	var ids []string
+	err := s.index.ScanTag(normalizeTag(tag), "", func(meta *models.Metadata) bool {
+		ids = append(ids, meta.ID)
+		return true
+	})
+	return ids, err
+}
+
+// listStoredIDs returns the sorted union of IDs in both storage tiers.
+func (s *ImageService) listStoredIDs(prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	ids, err := s.primary.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		seen[id] = true
+	}
+
+	if s.secondary != nil {
+		ids, err := s.secondary.List()
+		if err != nil {
+			log.Printf("Warning: Failed to list secondary storage: %v", err)
+		}
+		for _, id := range ids {
+			seen[id] = true
+		}
+	}
+
+	result := make([]string, 0, len(seen))
+	for id := range seen {
+		if strings.HasPrefix(id, prefix) {
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
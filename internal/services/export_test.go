@@ -0,0 +1,134 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/archive"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+// readArchive returns the entries of a zip or tar export by name.
+func readArchive(t *testing.T, format string, data []byte) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{}
+	if format == "zip" {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name], _ = io.ReadAll(rc)
+			rc.Close()
+		}
+		return files
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name], _ = io.ReadAll(tr)
+	}
+}
+
+func newExportService(t *testing.T) (*ImageService, storage.Storage) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := storage.NewFileSystemStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ImageService{primary: fs}
+	for id, c := range map[string]color.Color{"old": color.White, "new": color.Black} {
+		if err := fs.Save(losslessMaster(t, id, c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Masters are stored two ID characters per directory level
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "ol", "d.webp"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	return s, fs
+}
+
+func TestExport(t *testing.T) {
+	s, fs := newExportService(t)
+	for _, format := range []string{"zip", "tar"} {
+		buf := new(bytes.Buffer)
+		if err := s.Export(buf, format, ExportQuery{IDs: []string{"new", "old", "missing", "../etc"}}); err != nil {
+			t.Fatal(err)
+		}
+		files := readArchive(t, format, buf.Bytes())
+		if len(files) != 3 {
+			t.Fatalf("%s: got %d entries, want two images and the manifest", format, len(files))
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(files[archive.ManifestName], &manifest); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if manifest.Count != 2 || len(manifest.Images) != 4 {
+			t.Fatalf("%s: manifest = %+v", format, manifest)
+		}
+		for _, entry := range manifest.Images[:2] {
+			stored, err := fs.Get(entry.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(stored.Data)
+			if !bytes.Equal(files[entry.File], stored.Data) || entry.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: %s does not match the stored image", format, entry.File)
+			}
+			if entry.Width != 16 || entry.Height != 16 || entry.Source != "primary" {
+				t.Errorf("%s: entry = %+v", format, entry)
+			}
+		}
+		if manifest.Images[2].Error != "image not found" || manifest.Images[3].Error != "invalid image ID" {
+			t.Errorf("%s: failed entries = %+v", format, manifest.Images[2:])
+		}
+	}
+}
+
+func TestExportModifiedRange(t *testing.T) {
+	s, _ := newExportService(t)
+	cutoff := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query ExportQuery
+		want  string
+	}{
+		{ExportQuery{ModifiedAfter: cutoff}, "new"},
+		{ExportQuery{ModifiedBefore: cutoff}, "old"},
+		{ExportQuery{IDs: []string{"old", "new"}, ModifiedAfter: cutoff}, "new"},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		if err := s.Export(buf, "zip", tt.query); err != nil {
+			t.Fatal(err)
+		}
+		var manifest Manifest
+		json.Unmarshal(readArchive(t, "zip", buf.Bytes())[archive.ManifestName], &manifest)
+		if manifest.Count != 1 || manifest.Images[0].ID != tt.want {
+			t.Errorf("%+v: manifest = %+v, want only %s", tt.query, manifest.Images, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import "github.com/kartex/imageprovider/internal/transform"
+
+// FormatInfo describes an accepted upload or output format.
+type FormatInfo struct {
+	Format     string   `json:"format"`
+	MIMEType   string   `json:"mime_type"`
+	Extensions []string `json:"extensions,omitempty"`
+	Animated   bool     `json:"animated,omitempty"`
+}
+
+// inputFormats lists the decoders registered by this package's imports.
+// WebP decoding comes from github.com/chai2010/webp.
+var inputFormats = []FormatInfo{
+	{Format: "jpeg", MIMEType: "image/jpeg", Extensions: []string{".jpg", ".jpeg"}},
+	{Format: "png", MIMEType: "image/png", Extensions: []string{".png"}},
+	{Format: "gif", MIMEType: "image/gif", Extensions: []string{".gif"}, Animated: true},
+	{Format: "webp", MIMEType: "image/webp", Extensions: []string{".webp"}, Animated: true},
+	{Format: "tiff", MIMEType: "image/tiff", Extensions: []string{".tif", ".tiff"}},
+	{Format: "bmp", MIMEType: "image/bmp", Extensions: []string{".bmp"}},
+}
+
+// InputFormats returns the formats accepted for upload.
+func InputFormats() []FormatInfo {
+	return inputFormats
+}
+
+// OutputFormats returns the formats transformations can produce.
+func OutputFormats() []FormatInfo {
+	formats := transform.Formats()
+	out := make([]FormatInfo, len(formats))
+	for i, f := range formats {
+		out[i] = FormatInfo{
+			Format:   f,
+			MIMEType: transform.ContentType(f),
+			Animated: f == "webp" || f == "gif",
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/chai2010/webp"
+	"github.com/kartex/imageprovider/internal/storage"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func TestIngestFormats(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []byte{200, 40, 10, 255})
+	}
+	src.SetNRGBA(5, 3, color.NRGBA{10, 40, 200, 255})
+
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"bmp":  func(w io.Writer, m image.Image) error { return bmp.Encode(w, m) },
+		"tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+		"webp": func(w io.Writer, m image.Image) error { return webp.Encode(w, m, &webp.Options{Lossless: true}) },
+	}
+
+	fs, err := storage.NewFileSystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewImageService(fs, nil, nil)
+	for format, encode := range encoders {
+		buf := new(bytes.Buffer)
+		if err := encode(buf, src); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := s.Ingest("scan."+format, buf.Bytes(), UploadOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if meta.ID != "scan" || meta.Format != format || meta.Width != 6 || meta.Height != 4 {
+			t.Errorf("%s: metadata = %+v", format, meta)
+		}
+
+		// Every format is stored as a lossless WebP master
+		img, err := s.GetImage("scan")
+		if err != nil {
+			t.Fatal(err)
+		}
+		master, err := webp.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			t.Fatalf("%s: stored master: %v", format, err)
+		}
+		for _, p := range []image.Point{{0, 0}, {5, 3}} {
+			if got, want := color.NRGBAModel.Convert(master.At(p.X, p.Y)), src.At(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", format, p, got, want)
+			}
+		}
+	}
+
+	if _, err := s.Ingest("notes.txt", []byte("not an image"), UploadOptions{}); err != ErrInvalidImage {
+		t.Errorf("text upload: err = %v, want ErrInvalidImage", err)
+	}
+}
+
+func TestInputFormats(t *testing.T) {
+	want := map[string]bool{"jpeg": true, "png": true, "gif": true, "webp": true, "tiff": true, "bmp": true}
+	for _, f := range InputFormats() {
+		delete(want, f.Format)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing input formats: %v", want)
+	}
+}
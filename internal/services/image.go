@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -15,8 +16,14 @@ import (
 	"sync"
 
 	"github.com/chai2010/webp"
+	"github.com/kartex/imageprovider/internal/cache"
+	"github.com/kartex/imageprovider/internal/index"
 	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/pool"
 	"github.com/kartex/imageprovider/internal/storage"
+	"github.com/kartex/imageprovider/internal/transform"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
 )
 
 const (
@@ -24,17 +31,34 @@ const (
 	defaultMaxCacheMB   = 100 // 100MB default size limit
 )
 
+var (
+	ErrInvalidImage = errors.New("invalid image format")
+	ErrNotFound     = errors.New("image not found")
+)
+
 type ImageService struct {
-	images     []*models.Image
-	mu         sync.RWMutex
-	primary    storage.Storage
-	secondary  storage.Storage
-	maxSize    int
-	maxBytes   int64
-	totalBytes int64
+	images       []*models.Image
+	uncached     uint64 // bumped when an image leaves the cache, see getImage
+	mu           sync.RWMutex
+	primary      storage.Storage
+	secondary    storage.Storage
+	index        *index.Index
+	ingest       ingestOptions
+	variants     *cache.MemoryCache
+	presets      transform.Presets
+	eagerPresets []string
+	policies     map[string]*UploadPolicy
+	pool         *pool.Pool
+	reencode     *reencodeJob
+	writes       idLocks
+	maxSize      int
+	maxBytes     int64
+	totalBytes   int64
 }
 
-func NewImageService(primary storage.Storage, secondary storage.Storage) *ImageService {
+// NewImageService creates the service. The metadata index is optional; when
+// idx is nil listings fall back to walking storage.
+func NewImageService(primary storage.Storage, secondary storage.Storage, idx *index.Index) *ImageService {
 	maxCacheSize := defaultMaxCacheSize
 	if maxCacheStr := os.Getenv("MAX_CACHE_FILES"); maxCacheStr != "" {
 		if size, err := strconv.Atoi(maxCacheStr); err == nil && size > 0 {
@@ -49,13 +73,22 @@ func NewImageService(primary storage.Storage, secondary storage.Storage) *ImageS
 		}
 	}
 
+	presets := loadPresets()
 	return &ImageService{
-		images:     make([]*models.Image, 0, maxCacheSize),
-		primary:    primary,
-		secondary:  secondary,
-		maxSize:    maxCacheSize,
-		maxBytes:   int64(maxCacheMB) * 1024 * 1024, // Convert MB to bytes
-		totalBytes: 0,
+		images:       make([]*models.Image, 0, maxCacheSize),
+		primary:      primary,
+		secondary:    secondary,
+		index:        idx,
+		ingest:       loadIngestOptions(),
+		variants:     newVariantCache(),
+		presets:      presets,
+		eagerPresets: loadEagerPresets(presets),
+		policies:     loadUploadPolicies(),
+		pool:         newProcessingPool(),
+		reencode:     newReencodeJob(),
+		maxSize:      maxCacheSize,
+		maxBytes:     int64(maxCacheMB) * 1024 * 1024, // Convert MB to bytes
+		totalBytes:   0,
 	}
 }
 
@@ -106,37 +139,34 @@ func (s *ImageService) AddImage(image *models.Image) error {
 	return nil
 }
 
+// GetImage returns a stored image. Legacy masters that are not WebP are
+// converted on the interactive lane of the processing pool.
 func (s *ImageService) GetImage(id string) (*models.Image, error) {
+	return s.getImage(id, func(fn func()) error { return s.pool.Do(pool.Interactive, fn) })
+}
+
+// getImageOnWorker is GetImage for code already running on the pool, which
+// must not queue again.
+func (s *ImageService) getImageOnWorker(id string) (*models.Image, error) {
+	return s.getImage(id, func(fn func()) error { fn(); return nil })
+}
+
+// getImage looks an image up in the cache, then in primary and secondary
+// storage. run executes any conversion to WebP.
+func (s *ImageService) getImage(id string, run func(func()) error) (*models.Image, error) {
 	// Get base filename without extension
 	baseID := strings.TrimSuffix(id, filepath.Ext(id))
 
 	s.mu.RLock()
+	uncached := s.uncached
 	// Check cache for any version of this file (with any extension)
 	for _, img := range s.images {
 		imgBaseID := strings.TrimSuffix(img.ID, filepath.Ext(img.ID))
 		if imgBaseID == baseID {
 			s.mu.RUnlock()
-			// If it's an image and not in WebP format, convert it
-			if _, _, err := image.Decode(bytes.NewReader(img.Data)); err == nil {
-				if img.Format == "webp" {
-					// If it's already in WebP format, return it directly
-					return img, nil
-				}
-				// Convert to WebP if not already
-				decoded, _, err := image.Decode(bytes.NewReader(img.Data))
-				if err != nil {
-					log.Printf("Warning: Failed to decode cached image: %v", err)
-					return nil, err
-				}
-
-				buf := new(bytes.Buffer)
-				if err := webp.Encode(buf, decoded, &webp.Options{Lossless: true}); err != nil {
-					log.Printf("Warning: Failed to encode cached image to WebP: %v", err)
-					return nil, err
-				}
-
-				img.Data = buf.Bytes()
-				img.Format = "webp"
+			if err := convertToWebP(img, run); err != nil {
+				log.Printf("Warning: Failed to convert cached image to WebP: %v", err)
+				return nil, err
 			}
 			return img, nil
 		}
@@ -146,33 +176,12 @@ func (s *ImageService) GetImage(id string) (*models.Image, error) {
 	// If not in cache, try primary storage
 	img, err := s.primary.Get(id)
 	if err == nil {
-		// If it's an image, ensure it's in WebP format
-		if _, _, err := image.Decode(bytes.NewReader(img.Data)); err == nil {
-			if img.Format != "webp" {
-				decoded, _, err := image.Decode(bytes.NewReader(img.Data))
-				if err != nil {
-					log.Printf("Warning: Failed to decode image from primary storage: %v", err)
-					return nil, err
-				}
-
-				buf := new(bytes.Buffer)
-				if err := webp.Encode(buf, decoded, &webp.Options{Lossless: true}); err != nil {
-					log.Printf("Warning: Failed to encode image to WebP: %v", err)
-					return nil, err
-				}
-
-				img.Data = buf.Bytes()
-				img.Format = "webp"
-			}
+		if err := convertToWebP(img, run); err != nil {
+			log.Printf("Warning: Failed to convert image from primary storage to WebP: %v", err)
+			return nil, err
 		}
 
-		// Add to cache
-		s.mu.Lock()
-		if len(s.images) >= s.maxSize {
-			s.images = s.images[1:]
-		}
-		s.images = append(s.images, img)
-		s.mu.Unlock()
+		s.cacheRead(img, uncached)
 		return img, nil
 	}
 
@@ -181,61 +190,51 @@ func (s *ImageService) GetImage(id string) (*models.Image, error) {
 		log.Printf("Image not found in primary storage, trying secondary storage for ID: %s", id)
 		img, err = s.secondary.Get(id)
 		if err == nil {
-			// If it's an image, ensure it's in WebP format
-			if _, _, err := image.Decode(bytes.NewReader(img.Data)); err == nil {
-				if img.Format != "webp" {
-					decoded, _, err := image.Decode(bytes.NewReader(img.Data))
-					if err != nil {
-						log.Printf("Warning: Failed to decode image from secondary storage: %v", err)
-						return nil, err
-					}
-
-					buf := new(bytes.Buffer)
-					if err := webp.Encode(buf, decoded, &webp.Options{Lossless: true}); err != nil {
-						log.Printf("Warning: Failed to encode image to WebP: %v", err)
-						return nil, err
-					}
-
-					img.Data = buf.Bytes()
-					img.Format = "webp"
-				}
-			} else {
-				// Not an image file, keep original format
-				log.Printf("File is not an image, keeping original format: %s", img.Format)
+			if err := convertToWebP(img, run); err != nil {
+				log.Printf("Warning: Failed to convert image from secondary storage to WebP: %v", err)
+				return nil, err
 			}
 
-			// Add to cache
-			s.mu.Lock()
-			if len(s.images) >= s.maxSize {
-				s.images = s.images[1:]
-			}
-			s.images = append(s.images, img)
-			s.mu.Unlock()
+			s.cacheRead(img, uncached)
 
 			// Save to primary storage for future access
 			if err := s.primary.Save(img); err != nil {
 				log.Printf("Warning: Failed to save to primary storage after retrieving from secondary: %v", err)
+			} else {
+				s.addLocation(id, models.LocationPrimary)
 			}
 			return img, nil
 		}
 		log.Printf("Image not found in secondary storage for ID: %s", id)
 	}
 
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
 	return nil, err
 }
 
+// cacheRead adds an image read from storage to the cache, unless an image
+// was replaced or deleted since the read began and it may be the old version.
+func (s *ImageService) cacheRead(img *models.Image, uncached uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uncached != uncached {
+		return
+	}
+	if len(s.images) >= s.maxSize {
+		s.images = s.images[1:]
+	}
+	s.images = append(s.images, img)
+}
+
 func (s *ImageService) DeleteImage(id string) error {
+	defer s.writes.lock(id)()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Remove from cache
-	for i, img := range s.images {
-		if img.ID == id {
-			s.totalBytes -= int64(len(img.Data))
-			s.images = append(s.images[:i], s.images[i+1:]...)
-			break
-		}
-	}
+	s.uncacheLocked(id)
 
 	// Delete from primary storage
 	if err := s.primary.Delete(id); err != nil {
@@ -248,7 +247,68 @@ func (s *ImageService) DeleteImage(id string) error {
 			log.Printf("Warning: Failed to delete image from secondary storage: %v", err)
 		}
 	}
+	s.deleteOriginal(id)
+
+	// Remove from metadata index
+	if s.index != nil {
+		if err := s.index.Delete(id); err != nil {
+			log.Printf("Warning: Failed to remove image %s from index: %v", id, err)
+		}
+	}
 
+	// Drop cached variants last, so none rendered from the deleted files
+	// while they were being removed is kept
+	s.dropVariants(id)
+	return nil
+}
+
+// uncache removes an image from the in-memory cache after its master was
+// replaced.
+func (s *ImageService) uncache(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uncacheLocked(id)
+}
+
+func (s *ImageService) uncacheLocked(id string) {
+	s.uncached++
+	for i, img := range s.images {
+		if img.ID == id {
+			s.totalBytes -= int64(len(img.Data))
+			s.images = append(s.images[:i], s.images[i+1:]...)
+			return
+		}
+	}
+}
+
+// convertToWebP re-encodes an image that is not WebP as lossless WebP.
+// Files that are not images keep their original format.
+func convertToWebP(img *models.Image, run func(func()) error) error {
+	if img.Format == "webp" {
+		return nil
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err != nil {
+		return nil
+	}
+
+	var data []byte
+	var err error
+	if perr := run(func() {
+		var decoded image.Image
+		if decoded, _, err = image.Decode(bytes.NewReader(img.Data)); err != nil {
+			return
+		}
+		buf := new(bytes.Buffer)
+		if err = webp.Encode(buf, decoded, &webp.Options{Lossless: true}); err == nil {
+			data = buf.Bytes()
+		}
+	}); perr != nil {
+		return perr
+	}
+	if err != nil {
+		return err
+	}
+	img.Data, img.Format = data, "webp"
 	return nil
 }
 
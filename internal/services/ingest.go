@@ -0,0 +1,429 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/kartex/imageprovider/internal/animation"
+	"github.com/kartex/imageprovider/internal/exif"
+	"github.com/kartex/imageprovider/internal/icc"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/phash"
+	"github.com/kartex/imageprovider/internal/pool"
+)
+
+const (
+	defaultMaxFrames           = 300
+	defaultMaxAnimationSeconds = 60
+)
+
+var ErrAnimationLimit = errors.New("animation exceeds the configured limits")
+
+// Color profile policies, selected with COLOR_PROFILE_POLICY.
+const (
+	colorPolicyConvert = "convert" // convert pixels to sRGB and drop the profile
+	colorPolicyEmbed   = "embed"   // keep pixels and embed the profile in the WebP
+	colorPolicyIgnore  = "ignore"  // drop the profile without converting
+)
+
+// ingestOptions controls how uploads are processed before storage.
+type ingestOptions struct {
+	preserveEXIF  bool
+	preserveXMP   bool
+	keepGPS       bool
+	colorPolicy   string
+	animate       bool
+	maxFrames     int
+	maxDuration   int // milliseconds
+	limits        decodeLimits
+	keepOriginals bool
+
+	duplicates          string
+	similarityThreshold int
+	paletteSize         int
+}
+
+// loadIngestOptions reads METADATA_PRESERVE (a comma-separated list of
+// "exif" and "xmp"), METADATA_KEEP_GPS, COLOR_PROFILE_POLICY, KEEP_ORIGINALS,
+// DUPLICATE_POLICY, SIMILARITY_THRESHOLD, PALETTE_SIZE and the ANIMATION_*
+// settings. By default all embedded metadata is stripped, location data is
+// never kept, wide gamut images are converted to sRGB, original uploads are
+// kept and animated GIFs stay animated.
+func loadIngestOptions() ingestOptions {
+	var opts ingestOptions
+	for _, v := range strings.Split(os.Getenv("METADATA_PRESERVE"), ",") {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "exif":
+			opts.preserveEXIF = true
+		case "xmp":
+			opts.preserveXMP = true
+		}
+	}
+	opts.keepGPS = os.Getenv("METADATA_KEEP_GPS") == "true"
+
+	switch policy := os.Getenv("COLOR_PROFILE_POLICY"); policy {
+	case colorPolicyEmbed, colorPolicyIgnore:
+		opts.colorPolicy = policy
+	default:
+		opts.colorPolicy = colorPolicyConvert
+	}
+
+	opts.limits = loadDecodeLimits()
+	opts.keepOriginals = os.Getenv("KEEP_ORIGINALS") != "false"
+	switch policy := os.Getenv("DUPLICATE_POLICY"); policy {
+	case DuplicatesFlag, DuplicatesReject:
+		opts.duplicates = policy
+	default:
+		opts.duplicates = DuplicatesAllow
+	}
+	opts.similarityThreshold = defaultSimilarityThreshold
+	if n, err := strconv.Atoi(os.Getenv("SIMILARITY_THRESHOLD")); err == nil && n > 0 {
+		opts.similarityThreshold = min(n, MaxSimilarityThreshold)
+	}
+	opts.paletteSize = defaultPaletteSize
+	if n, err := strconv.Atoi(os.Getenv("PALETTE_SIZE")); err == nil && n > 0 {
+		opts.paletteSize = min(n, maxPaletteSize)
+	}
+	opts.animate = os.Getenv("ANIMATION_MODE") != "poster"
+	opts.maxFrames = defaultMaxFrames
+	if n, err := strconv.Atoi(os.Getenv("ANIMATION_MAX_FRAMES")); err == nil && n > 0 {
+		opts.maxFrames = n
+	}
+	opts.maxDuration = defaultMaxAnimationSeconds * 1000
+	if n, err := strconv.Atoi(os.Getenv("ANIMATION_MAX_SECONDS")); err == nil && n > 0 {
+		opts.maxDuration = n * 1000
+	}
+	return opts
+}
+
+// UploadOptions are chosen per upload. Zero values use the configured
+// defaults.
+type UploadOptions struct {
+	Duplicates         string
+	DuplicateThreshold int
+	Policy             *UploadPolicy // nil accepts anything within the decode limits
+}
+
+// Ingest decodes an uploaded file, converts it to WebP and adds it to the service.
+// The returned metadata records the original upload format.
+// Decoding and encoding run on the bulk lane of the processing pool.
+func (s *ImageService) Ingest(filename string, data []byte, opts UploadOptions) (meta *models.Metadata, err error) {
+	// Reject decompression bombs from their headers before allocating pixels
+	header, err := s.checkDecodeLimits(data)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Policy != nil {
+		if err := opts.Policy.check(data, header); err != nil {
+			return nil, err
+		}
+	}
+
+	if perr := s.pool.Do(pool.Bulk, func() { meta, err = s.ingestData(filename, data, opts) }); perr != nil {
+		return nil, perr
+	}
+	return meta, err
+}
+
+func (s *ImageService) ingestData(filename string, data []byte, opts UploadOptions) (*models.Metadata, error) {
+	// Decode the image (supports multiple formats)
+	var decoded image.Image
+	var format string
+	var err error
+	if animation.IsAnimatedWebP(data) {
+		anim, err := s.decodeAnimatedWebP(data)
+		if err != nil {
+			return nil, err
+		}
+		if s.ingest.animate {
+			return s.ingestAnimation(filename, "webp", anim, data, opts)
+		}
+		decoded, format = anim.Frames[0].Image, "webp"
+	} else if decoded, format, err = image.Decode(bytes.NewReader(data)); err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	// image.Decode only returns the first frame of a GIF
+	if format == "gif" && s.ingest.animate {
+		anim, err := s.decodeAnimatedGIF(data)
+		if err != nil {
+			return nil, err
+		}
+		if anim != nil {
+			return s.ingestAnimation(filename, "gif", anim, data, opts)
+		}
+	}
+
+	decoded, info := s.normalize(filename, data, decoded)
+
+	hash := phash.Compute(decoded)
+	duplicates, err := s.checkDuplicates(imageID(filename), hash, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to WebP
+	buf := new(bytes.Buffer)
+	if err := webp.Encode(buf, decoded, &webp.Options{Lossless: true}); err != nil {
+		return nil, fmt.Errorf("failed to convert to WebP: %w", err)
+	}
+	encoded, err := s.embedMetadata(buf.Bytes(), data, info.rawEXIF, info.profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed metadata: %w", err)
+	}
+
+	bounds := decoded.Bounds()
+	img := &models.Image{
+		ID:     imageID(filename),
+		Data:   encoded,
+		Format: "webp",
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+	if err := s.storeIngested(img, data); err != nil {
+		return nil, err
+	}
+
+	meta := buildMetadata(img, models.LocationPrimary, time.Now().UTC())
+	meta.Format = format
+	meta.ColorProfile = info.colorProfile
+	meta.Placeholder = computePlaceholder(decoded)
+	meta.PHash = hash.String()
+	meta.Colors = s.computeColors(decoded)
+	meta.NearDuplicates = duplicates
+	if info.exif != nil {
+		meta.EXIF = s.exifMetadata(info.exif)
+	}
+	s.finishIngest(img, meta)
+	return meta, nil
+}
+
+// decodeAnimatedGIF returns nil for single-frame GIFs and for GIFs whose
+// later frames are malformed, which are stored as stills.
+func (s *ImageService) decodeAnimatedGIF(data []byte) (*animation.Animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) < 2 {
+		return nil, nil
+	}
+	if err := s.checkAnimation(len(g.Image), 0); err != nil {
+		return nil, err
+	}
+
+	anim, err := animation.FromGIF(g)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if err := s.checkAnimation(len(anim.Frames), anim.Duration()); err != nil {
+		return nil, err
+	}
+	return anim, nil
+}
+
+// decodeAnimatedWebP checks the limits before decoding any frame.
+func (s *ImageService) decodeAnimatedWebP(data []byte) (*animation.Animation, error) {
+	info, _ := animation.Probe(data)
+	if err := s.checkAnimation(info.Frames, info.Duration); err != nil {
+		return nil, err
+	}
+	anim, err := animation.DecodeWebP(data)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return anim, nil
+}
+
+func (s *ImageService) checkAnimation(frames, duration int) error {
+	if frames > s.ingest.maxFrames {
+		return fmt.Errorf("%w: %d frames, the limit is %d", ErrAnimationLimit, frames, s.ingest.maxFrames)
+	}
+	if duration > s.ingest.maxDuration {
+		return fmt.Errorf("%w: %.1fs long, the limit is %ds", ErrAnimationLimit, float64(duration)/1000, s.ingest.maxDuration/1000)
+	}
+	return nil
+}
+
+// ingestAnimation stores an animation as an animated lossless WebP.
+func (s *ImageService) ingestAnimation(filename, format string, anim *animation.Animation, original []byte, opts UploadOptions) (*models.Metadata, error) {
+	// Animations are compared by their first frame
+	hash := phash.Compute(anim.Frames[0].Image)
+	duplicates, err := s.checkDuplicates(imageID(filename), hash, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := animation.EncodeWebP(buf, anim, 0); err != nil {
+		return nil, fmt.Errorf("failed to convert to WebP: %w", err)
+	}
+
+	img := &models.Image{
+		ID:     imageID(filename),
+		Data:   buf.Bytes(),
+		Format: "webp",
+		Width:  anim.Width,
+		Height: anim.Height,
+	}
+	if err := s.storeIngested(img, original); err != nil {
+		return nil, err
+	}
+
+	meta := buildMetadata(img, models.LocationPrimary, time.Now().UTC())
+	meta.Format = format
+	meta.Placeholder = computePlaceholder(anim.Frames[0].Image)
+	meta.PHash = hash.String()
+	meta.Colors = s.computeColors(anim.Frames[0].Image)
+	meta.NearDuplicates = duplicates
+	s.finishIngest(img, meta)
+	return meta, nil
+}
+
+func imageID(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+}
+
+// storeIngested saves a newly encoded image and its original upload, and
+// drops variants rendered from the previous version.
+func (s *ImageService) storeIngested(img *models.Image, original []byte) error {
+	defer s.writes.lock(img.ID)()
+	if err := s.primary.Save(img); err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	s.storeOriginal(img.ID, original)
+	s.uncache(img.ID)
+	s.dropVariants(img.ID)
+	return nil
+}
+
+// uploadInfo carries what normalize extracted from an upload's metadata.
+type uploadInfo struct {
+	profile      []byte // ICC profile to embed, if the policy keeps it
+	colorProfile string
+	rawEXIF      []byte
+	exif         *exif.Data
+}
+
+// normalize brings decoded upload pixels into their stored form: colors are
+// handled according to the color policy and the EXIF orientation is applied
+// so the pixels are upright.
+func (s *ImageService) normalize(filename string, data []byte, decoded image.Image) (image.Image, *uploadInfo) {
+	info := &uploadInfo{}
+	if profile, _ := icc.Extract(data); profile != nil {
+		info.colorProfile = icc.Describe(profile)
+		decoded, info.profile = s.applyColorProfile(decoded, profile)
+	}
+
+	if rawEXIF, _ := exif.Extract(data); rawEXIF != nil {
+		exifData, err := exif.Parse(rawEXIF)
+		if err != nil {
+			log.Printf("Warning: Ignoring malformed EXIF in %s: %v", filename, err)
+			return decoded, info
+		}
+		info.rawEXIF, info.exif = rawEXIF, exifData
+		decoded = exif.Orient(decoded, exifData.Orientation)
+	}
+	return decoded, info
+}
+
+func (s *ImageService) finishIngest(img *models.Image, meta *models.Metadata) {
+	s.recordMetadata(meta)
+	if err := s.AddImage(img); err != nil {
+		log.Printf("Warning: Failed to cache uploaded image %s: %v", img.ID, err)
+	}
+}
+
+// applyColorProfile handles an embedded ICC profile according to the color
+// policy. It returns the image to encode and the profile to embed, if any.
+// Profiles that cannot be converted are embedded so colors stay correct.
+func (s *ImageService) applyColorProfile(img image.Image, profile []byte) (image.Image, []byte) {
+	switch s.ingest.colorPolicy {
+	case colorPolicyIgnore:
+		return img, nil
+	case colorPolicyEmbed:
+		return img, profile
+	}
+
+	p, err := icc.Parse(profile)
+	if err != nil {
+		log.Printf("Warning: Cannot convert ICC profile %q to sRGB, embedding it instead: %v", icc.Describe(profile), err)
+		return img, profile
+	}
+	if p.IsSRGB() {
+		return img, nil
+	}
+	return p.ToSRGB(img), nil
+}
+
+// embedMetadata copies the EXIF and XMP metadata that the deployment chose to
+// preserve, and any ICC profile kept by the color policy, into the encoded
+// WebP. GPS data is removed unless explicitly kept.
+func (s *ImageService) embedMetadata(encoded, original, rawEXIF, profile []byte) ([]byte, error) {
+	if profile != nil {
+		var err error
+		if encoded, err = webp.SetMetadata(encoded, profile, "ICCP"); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.ingest.preserveEXIF && rawEXIF != nil {
+		// Pixels are already rotated, so the tag must no longer rotate them
+		payload, err := exif.ResetOrientation(rawEXIF)
+		if err != nil {
+			return nil, err
+		}
+		if !s.ingest.keepGPS {
+			if payload, err = exif.StripGPS(payload); err != nil {
+				return nil, err
+			}
+		}
+		if encoded, err = webp.SetMetadata(encoded, payload, "EXIF"); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.ingest.preserveXMP {
+		if xmp, err := exif.ExtractXMP(original); err == nil {
+			if !s.ingest.keepGPS {
+				xmp = exif.StripXMPLocation(xmp)
+			}
+			if encoded, err = webp.SetMetadata(encoded, xmp, "XMP"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return encoded, nil
+}
+
+// exifMetadata converts parsed EXIF into the indexed representation.
+func (s *ImageService) exifMetadata(d *exif.Data) *models.EXIF {
+	e := &models.EXIF{
+		Make:         d.Make,
+		Model:        d.Model,
+		LensModel:    d.LensModel,
+		ExposureTime: d.ExposureTime,
+		FNumber:      d.FNumber,
+		ISO:          d.ISO,
+		FocalLength:  d.FocalLength,
+		Orientation:  d.Orientation,
+	}
+	if !d.TakenAt.IsZero() {
+		takenAt := d.TakenAt
+		e.TakenAt = &takenAt
+	}
+	if d.HasGPS && s.ingest.keepGPS {
+		lat, lon := d.Latitude, d.Longitude
+		e.Latitude = &lat
+		e.Longitude = &lon
+	}
+	return e
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+
+	"github.com/kartex/imageprovider/internal/animation"
+)
+
+const (
+	defaultMaxImageWidth      = 16384
+	defaultMaxImageHeight     = 16384
+	defaultMaxImageMegapixels = 100
+)
+
+var ErrImageTooLarge = errors.New("image exceeds the configured limits")
+
+// decodeLimits bound the memory a single upload can make the decoder
+// allocate. They are checked against the file headers before decoding.
+type decodeLimits struct {
+	maxWidth  int
+	maxHeight int
+	maxPixels int64 // across all frames of an animation
+}
+
+// loadDecodeLimits reads MAX_IMAGE_WIDTH, MAX_IMAGE_HEIGHT and
+// MAX_IMAGE_MEGAPIXELS.
+func loadDecodeLimits() decodeLimits {
+	limits := decodeLimits{
+		maxWidth:  defaultMaxImageWidth,
+		maxHeight: defaultMaxImageHeight,
+		maxPixels: defaultMaxImageMegapixels * 1000 * 1000,
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_IMAGE_WIDTH")); err == nil && n > 0 {
+		limits.maxWidth = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_IMAGE_HEIGHT")); err == nil && n > 0 {
+		limits.maxHeight = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_IMAGE_MEGAPIXELS")); err == nil && n > 0 {
+		limits.maxPixels = int64(n) * 1000 * 1000
+	}
+	return limits
+}
+
+// uploadHeader is what the file headers tell about an upload.
+type uploadHeader struct {
+	format        string
+	width, height int
+	frames        int
+}
+
+// checkDecodeLimits reads only the headers of an upload and rejects it when
+// decoding would exceed the size, pixel or frame limits.
+func (s *ImageService) checkDecodeLimits(data []byte) (*uploadHeader, error) {
+	header := &uploadHeader{frames: 1}
+	if info, ok := animation.Probe(data); ok {
+		header.format = "webp"
+		header.width, header.height, header.frames = info.Width, info.Height, info.Frames
+	} else {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		header.format = format
+		header.width, header.height = cfg.Width, cfg.Height
+		if format == "gif" && s.ingest.animate {
+			if n, err := animation.CountGIFFrames(data); err == nil {
+				header.frames = max(n, 1)
+			}
+		}
+	}
+	w, h, frames := header.width, header.height, header.frames
+
+	limits := s.ingest.limits
+	if w <= 0 || h <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if w > limits.maxWidth || h > limits.maxHeight {
+		return nil, fmt.Errorf("%w: %dx%d is larger than %dx%d", ErrImageTooLarge, w, h, limits.maxWidth, limits.maxHeight)
+	}
+	if frames > s.ingest.maxFrames {
+		return nil, fmt.Errorf("%w: %d frames, the limit is %d", ErrAnimationLimit, frames, s.ingest.maxFrames)
+	}
+	if pixels := int64(w) * int64(h) * int64(frames); pixels > limits.maxPixels {
+		return nil, fmt.Errorf("%w: %.1f megapixels is more than %d", ErrImageTooLarge, float64(pixels)/1e6, limits.maxPixels/1e6)
+	}
+	return header, nil
+}
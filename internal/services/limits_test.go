@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/testutil"
+)
+
+func testLimitsService() *ImageService {
+	return &ImageService{ingest: ingestOptions{
+		animate:   true,
+		maxFrames: 100,
+		limits: decodeLimits{
+			maxWidth:  defaultMaxImageWidth,
+			maxHeight: defaultMaxImageHeight,
+			maxPixels: defaultMaxImageMegapixels * 1000 * 1000,
+		},
+	}}
+}
+
+// gifHeader is a GIF logical screen descriptor declaring w x h.
+func gifHeader(w, h uint16) []byte {
+	buf := bytes.NewBufferString("GIF89a")
+	binary.Write(buf, binary.LittleEndian, w)
+	binary.Write(buf, binary.LittleEndian, h)
+	buf.Write([]byte{0, 0, 0, 0x3B})
+	return buf.Bytes()
+}
+
+// gifFrames encodes a real 1x1 GIF animation with n frames.
+func gifFrames(t *testing.T, n int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < n; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette))
+		anim.Delay = append(anim.Delay, 1)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// animatedWebP is an animated WebP container with a w x h canvas and n empty
+// ANMF frame chunks.
+func animatedWebP(w, h uint32, n int) []byte {
+	body := new(bytes.Buffer)
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation flag
+	vp8x[4], vp8x[5], vp8x[6] = byte(w-1), byte((w-1)>>8), byte((w-1)>>16)
+	vp8x[7], vp8x[8], vp8x[9] = byte(h-1), byte((h-1)>>8), byte((h-1)>>16)
+	writeTestChunk(body, "VP8X", vp8x)
+	writeTestChunk(body, "ANIM", make([]byte, 6))
+	for i := 0; i < n; i++ {
+		writeTestChunk(body, "ANMF", make([]byte, 16))
+	}
+
+	buf := bytes.NewBufferString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(4+body.Len()))
+	buf.WriteString("WEBP")
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// webpFrameBomb is an animated WebP with a 1x1 canvas whose single 1x1 frame
+// carries a lossless bitstream declaring w x h.
+func webpFrameBomb(w, h uint32) []byte {
+	data := animatedWebP(1, 1, 0)
+	anmf := new(bytes.Buffer)
+	anmf.Write(make([]byte, 16))
+	writeTestChunk(anmf, "VP8L", testutil.VP8L(w, h))
+
+	body := bytes.NewBuffer(data[12:])
+	writeTestChunk(body, "ANMF", anmf.Bytes())
+	buf := bytes.NewBufferString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(4+body.Len()))
+	buf.WriteString("WEBP")
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func writeTestChunk(buf *bytes.Buffer, fourCC string, data []byte) {
+	buf.WriteString(fourCC)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+func TestCheckDecodeLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"png within limits", testutil.PNGHeader(4000, 3000), nil},
+		{"png 50000x50000", testutil.PNGHeader(50000, 50000), ErrImageTooLarge},
+		{"png too wide", testutil.PNGHeader(20000, 10), ErrImageTooLarge},
+		{"png over megapixels", testutil.PNGHeader(12000, 12000), ErrImageTooLarge},
+		{"png zero width", testutil.PNGHeader(0, 10), ErrInvalidImage},
+		{"gif 50000x50000", gifHeader(50000, 50000), ErrImageTooLarge},
+		{"gif within frame limit", gifFrames(t, 100), nil},
+		{"gif too many frames", gifFrames(t, 101), ErrAnimationLimit},
+		{"webp within limits", animatedWebP(1000, 1000, 10), nil},
+		{"webp oversized canvas", animatedWebP(50000, 50000, 1), ErrImageTooLarge},
+		{"webp too many frames", animatedWebP(10, 10, 101), ErrAnimationLimit},
+		{"webp over megapixels across frames", animatedWebP(4000, 4000, 10), ErrImageTooLarge},
+		{"garbage", []byte("not an image"), ErrInvalidImage},
+	}
+
+	s := testLimitsService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := s.checkDecodeLimits(tt.data)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if header.width <= 0 || header.height <= 0 {
+					t.Fatalf("header = %+v", header)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDecodeLimitsStillGIF(t *testing.T) {
+	// With animation disabled GIFs are stored as their first frame, so the
+	// frame count does not matter
+	s := testLimitsService()
+	s.ingest.animate = false
+	if _, err := s.checkDecodeLimits(gifFrames(t, 500)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeAnimatedWebPFrameBomb(t *testing.T) {
+	// The canvas passes the header checks; the frame bitstream must not be
+	// decoded at its own declared size
+	data := webpFrameBomb(16000, 16000)
+	s := testLimitsService()
+	if _, err := s.checkDecodeLimits(data); err != nil {
+		t.Fatalf("checkDecodeLimits: %v", err)
+	}
+	if _, err := s.decodeAnimatedWebP(data); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("err = %v, want ErrInvalidImage", err)
+	}
+}
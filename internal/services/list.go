@@ -0,0 +1,264 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// ListQuery filters and orders a page of stored images.
+type ListQuery struct {
+	Prefix         string
+	Tag            string
+	Cursor         string
+	Limit          int
+	Format         string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Sort           string // "id" (default), "size" or "modified"
+	Desc           bool
+	Secondary      bool
+}
+
+// ListResult is one page of images. NextCursor is empty on the last page.
+type ListResult struct {
+	Images     []*models.Metadata
+	NextCursor string
+}
+
+// ListImages pages through stored images, applying filters as it goes. The
+// metadata index is used when available; otherwise the storage tier is walked.
+// The default order pages straight from the index, in ID order, or from
+// storage, in its listing order. Other orders collect and sort every matching
+// image before cutting a page, which costs a full scan per page.
+func (s *ImageService) ListImages(q ListQuery) (*ListResult, error) {
+	if q.Secondary && s.secondary == nil {
+		return nil, fmt.Errorf("%w: secondary storage is not configured", ErrInvalidQuery)
+	}
+	if q.Tag != "" && s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = storage.DefaultPageSize
+	}
+	if limit > storage.MaxPageSize {
+		limit = storage.MaxPageSize
+	}
+
+	if q.Sort != "id" && q.Sort != "" || q.Desc {
+		return s.listSorted(q, limit)
+	}
+	if s.useIndex(q) {
+		return s.listFromIndex(q, limit)
+	}
+	return s.listFromStorage(q, limit)
+}
+
+func (s *ImageService) useIndex(q ListQuery) bool {
+	return s.index != nil && (!q.Secondary || q.Tag != "")
+}
+
+// listSorted pages through all matching images in the requested order. The
+// cursor holds the sort key and ID of the last image returned, so pages stay
+// consistent while images are added or removed.
+func (s *ImageService) listSorted(q ListQuery, limit int) (*ListResult, error) {
+	after, err := q.parseSortCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := q.Cursor
+	q.Cursor = ""
+	var all *ListResult
+	if s.useIndex(q) {
+		all, err = s.listFromIndex(q, 0)
+	} else {
+		all, err = s.listFromStorage(q, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.sortImages(all.Images)
+
+	images := all.Images
+	if cursor != "" {
+		i := sort.Search(len(images), func(i int) bool { return q.before(after, images[i]) })
+		images = images[i:]
+	}
+
+	result := &ListResult{Images: images}
+	if len(images) > limit {
+		result.Images = images[:limit]
+		result.NextCursor = q.sortCursor(images[limit-1])
+	}
+	return result, nil
+}
+
+// listFromIndex returns a page of matches in ID order; limit 0 returns all.
+func (s *ImageService) listFromIndex(q ListQuery, limit int) (*ListResult, error) {
+	result := &ListResult{Images: make([]*models.Metadata, 0, limit)}
+	visit := func(meta *models.Metadata) bool {
+		if !q.matches(meta) {
+			return true
+		}
+		if limit > 0 && len(result.Images) == limit {
+			result.NextCursor = result.Images[limit-1].ID
+			return false
+		}
+		result.Images = append(result.Images, meta)
+		return true
+	}
+
+	var err error
+	if q.Tag != "" {
+		err = s.index.ScanTag(normalizeTag(q.Tag), q.Cursor, visit)
+	} else {
+		err = s.index.Scan(q.Prefix, q.Cursor, visit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// listFromStorage returns a page of matches in ID order; limit 0 returns all.
+func (s *ImageService) listFromStorage(q ListQuery, limit int) (*ListResult, error) {
+	store := s.primary
+	location := models.LocationPrimary
+	if q.Secondary {
+		store = s.secondary
+		location = models.LocationSecondary
+	}
+
+	result := &ListResult{Images: make([]*models.Metadata, 0, limit)}
+	cursor := q.Cursor
+	pageSize := limit
+	if pageSize == 0 {
+		pageSize = storage.MaxPageSize
+	}
+	for {
+		page, err := store.ListPage(storage.ListOptions{
+			Prefix: q.Prefix,
+			Cursor: cursor,
+			Limit:  pageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, obj := range page.Objects {
+			meta := &models.Metadata{
+				ID:        obj.ID,
+				Format:    "webp",
+				Size:      obj.Size,
+				Locations: []string{location},
+				CreatedAt: obj.ModTime,
+				UpdatedAt: obj.ModTime,
+			}
+			if !q.matches(meta) {
+				continue
+			}
+			result.Images = append(result.Images, meta)
+			if limit > 0 && len(result.Images) == limit {
+				// Resume after this item unless it was the very last one
+				if i < len(page.Objects)-1 || page.NextCursor != "" {
+					result.NextCursor = meta.ID
+				}
+				return result, nil
+			}
+		}
+
+		if page.NextCursor == "" {
+			return result, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (q ListQuery) matches(meta *models.Metadata) bool {
+	if !strings.HasPrefix(meta.ID, q.Prefix) {
+		return false
+	}
+	if q.Secondary && !meta.HasLocation(models.LocationSecondary) {
+		return false
+	}
+	if q.Format != "" && !strings.EqualFold(q.Format, meta.Format) {
+		return false
+	}
+	if q.MinSize > 0 && meta.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && meta.Size > q.MaxSize {
+		return false
+	}
+	if !q.ModifiedAfter.IsZero() && meta.UpdatedAt.Before(q.ModifiedAfter) {
+		return false
+	}
+	if !q.ModifiedBefore.IsZero() && meta.UpdatedAt.After(q.ModifiedBefore) {
+		return false
+	}
+	return true
+}
+
+// sortKey is the value images are ordered by; ties are broken by ID.
+func (q ListQuery) sortKey(meta *models.Metadata) int64 {
+	switch q.Sort {
+	case "size":
+		return meta.Size
+	case "modified":
+		return meta.UpdatedAt.UnixNano()
+	}
+	return 0
+}
+
+// before reports whether the position (key, id) comes before meta in the
+// query's order.
+func (q ListQuery) before(pos sortPosition, meta *models.Metadata) bool {
+	key := q.sortKey(meta)
+	if key != pos.key {
+		return (pos.key < key) != q.Desc
+	}
+	if pos.id == meta.ID {
+		return false
+	}
+	return (pos.id < meta.ID) != q.Desc
+}
+
+func (q ListQuery) sortImages(images []*models.Metadata) {
+	sort.Slice(images, func(i, j int) bool {
+		return q.before(sortPosition{key: q.sortKey(images[i]), id: images[i].ID}, images[j])
+	})
+}
+
+type sortPosition struct {
+	key int64
+	id  string
+}
+
+func (q ListQuery) sortCursor(meta *models.Metadata) string {
+	return strconv.FormatInt(q.sortKey(meta), 10) + "~" + meta.ID
+}
+
+func (q ListQuery) parseSortCursor() (sortPosition, error) {
+	if q.Cursor == "" {
+		return sortPosition{}, nil
+	}
+	key, id, ok := strings.Cut(q.Cursor, "~")
+	n, err := strconv.ParseInt(key, 10, 64)
+	if !ok || err != nil {
+		return sortPosition{}, fmt.Errorf("%w: invalid cursor %q", ErrInvalidQuery, q.Cursor)
+	}
+	return sortPosition{key: n, id: id}, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"image/color"
+	"slices"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+func TestListSortedTiedKeys(t *testing.T) {
+	fs, err := storage.NewFileSystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ImageService{primary: fs}
+
+	// Five images share one size and one is larger, so pages split ties
+	for _, id := range []string{"e", "b", "d", "a", "c"} {
+		if err := fs.Save(losslessMaster(t, id, color.White)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	big := losslessMaster(t, "z", color.White)
+	big.Data = append(big.Data, make([]byte, 64)...)
+	if err := fs.Save(big); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc bool
+		want []string
+	}{
+		{false, []string{"a", "b", "c", "d", "e", "z"}},
+		{true, []string{"z", "e", "d", "c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		q := ListQuery{Sort: "size", Desc: tt.desc, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(tt.want) {
+				t.Fatalf("desc=%v: cursor does not advance: %v", tt.desc, got)
+			}
+			result, err := s.ListImages(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, meta := range result.Images {
+				got = append(got, meta.ID)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			q.Cursor = result.NextCursor
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("desc=%v: got %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestListSortedInvalidCursor(t *testing.T) {
+	s := &ImageService{}
+	for _, cursor := range []string{"abc", "12", "x~id"} {
+		if _, err := s.ListImages(ListQuery{Sort: "size", Cursor: cursor}); err == nil {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+}
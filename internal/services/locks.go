@@ -0,0 +1,40 @@
+package services
+
+import "sync"
+
+// idLocks serialises writes to the same image ID, so an upload, a re-encode
+// and a delete of one image never interleave. Locks are dropped when unused.
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until id is free and returns the matching unlock.
+func (l *idLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*idLock{}
+	}
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &idLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
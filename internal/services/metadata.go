@@ -0,0 +1,452 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kartex/imageprovider/internal/animation"
+	"github.com/kartex/imageprovider/internal/index"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/phash"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+const reindexBatchSize = 500
+
+var ErrIndexDisabled = errors.New("metadata index is not configured")
+
+// ReindexReport summarises a rebuild of the metadata index from storage.
+type ReindexReport struct {
+	Indexed int      `json:"indexed"`
+	Removed int      `json:"removed"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// ConsistencyReport lists differences between the index and storage.
+type ConsistencyReport struct {
+	Indexed            int      `json:"indexed"`
+	Stored             int      `json:"stored"`
+	MissingFromIndex   []string `json:"missing_from_index,omitempty"`
+	MissingFromStorage []string `json:"missing_from_storage,omitempty"`
+	SizeMismatch       []string `json:"size_mismatch,omitempty"`
+	LocationMismatch   []string `json:"location_mismatch,omitempty"`
+}
+
+// Consistent reports whether the check found no differences.
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.MissingFromIndex) == 0 && len(r.MissingFromStorage) == 0 &&
+		len(r.SizeMismatch) == 0 && len(r.LocationMismatch) == 0
+}
+
+// GetInfo returns the metadata for an image, reading it from the index when
+// available and otherwise deriving it from the stored file.
+func (s *ImageService) GetInfo(id string) (*models.Metadata, error) {
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+
+	if s.index != nil {
+		meta, err := s.index.Get(id)
+		if err == nil {
+			return meta, nil
+		}
+		if !errors.Is(err, index.ErrNotFound) {
+			log.Printf("Warning: Failed to read index entry for %s: %v", id, err)
+		}
+	}
+
+	img, source, err := s.loadStored(id)
+	if err != nil {
+		return nil, err
+	}
+	return buildMetadata(img, source, time.Time{}), nil
+}
+
+// recordMetadata stores index metadata for a freshly saved image, keeping
+// the creation time and user-supplied fields of any previous entry.
+func (s *ImageService) recordMetadata(meta *models.Metadata) {
+	if s.index == nil {
+		return
+	}
+
+	if old, err := s.index.Get(meta.ID); err == nil {
+		meta.KeepUserFields(old)
+		for _, l := range old.Locations {
+			meta.AddLocation(l)
+		}
+	}
+
+	if err := s.index.Put(meta); err != nil {
+		log.Printf("Warning: Failed to index image %s: %v", meta.ID, err)
+	}
+}
+
+// addLocation records that an indexed image now also exists in a tier.
+func (s *ImageService) addLocation(id, location string) {
+	if s.index == nil {
+		return
+	}
+	_, err := s.index.Update(id, func(meta *models.Metadata) error {
+		meta.AddLocation(location)
+		return nil
+	})
+	if err != nil && !errors.Is(err, index.ErrNotFound) {
+		log.Printf("Warning: Failed to update index locations for %s: %v", id, err)
+	}
+}
+
+// Reindex rebuilds the metadata index from both storage tiers. Fields that
+// cannot be derived from the stored files (tags, alt text, attributes,
+// original format, creation time) are carried over from existing entries.
+func (s *ImageService) Reindex() (*ReindexReport, error) {
+	if s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+
+	locations, err := s.storedLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(locations))
+	for id := range locations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	report := &ReindexReport{}
+	now := time.Now().UTC()
+	batch := make([]*models.Metadata, 0, reindexBatchSize)
+
+	for _, id := range ids {
+		img, _, err := s.loadStored(id)
+		if err != nil {
+			log.Printf("Warning: Failed to read %s during reindex: %v", id, err)
+			report.Failed = append(report.Failed, id)
+			continue
+		}
+
+		meta := buildMetadata(img, "", now)
+		meta.Locations = locations[id]
+		if old, err := s.index.Get(id); err == nil {
+			meta.KeepIngestFields(old)
+			meta.KeepUserFields(old)
+			if old.SHA256 == meta.SHA256 {
+				meta.Placeholder = old.Placeholder
+				meta.PHash = old.PHash
+				meta.Colors = old.Colors
+			}
+		}
+		// Colors indexed before histograms existed are computed again
+		staleColors := meta.Colors == nil || meta.Colors.Histogram == nil && len(meta.Colors.Palette) > 0
+		if meta.Placeholder == nil || meta.PHash == "" || staleColors {
+			if decoded, err := decodeStill(img.Data); err == nil {
+				meta.Placeholder = computePlaceholder(decoded)
+				meta.PHash = phash.Compute(decoded).String()
+				meta.Colors = s.computeColors(decoded)
+			}
+		}
+
+		batch = append(batch, meta)
+		if len(batch) == reindexBatchSize {
+			if err := s.index.PutMany(batch); err != nil {
+				return nil, err
+			}
+			report.Indexed += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.index.PutMany(batch); err != nil {
+			return nil, err
+		}
+		report.Indexed += len(batch)
+	}
+
+	// Drop entries whose files no longer exist in any tier
+	var stale []string
+	err = s.index.Scan("", "", func(meta *models.Metadata) bool {
+		if _, ok := locations[meta.ID]; !ok {
+			stale = append(stale, meta.ID)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range stale {
+		if err := s.index.Delete(id); err != nil {
+			return nil, err
+		}
+		report.Removed++
+	}
+
+	return report, nil
+}
+
+// CheckIndex compares the index against storage without modifying either.
+// Only listings are read, so the check is cheap even for large libraries.
+func (s *ImageService) CheckIndex() (*ConsistencyReport, error) {
+	if s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+
+	sizes := make(map[string]int64)
+	cursor := ""
+	for {
+		page, err := s.primary.ListPage(storage.ListOptions{Cursor: cursor, Limit: storage.MaxPageSize})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			sizes[obj.ID] = obj.Size
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	locations, err := s.storedLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConsistencyReport{Stored: len(locations)}
+	seen := make(map[string]bool)
+	err = s.index.Scan("", "", func(meta *models.Metadata) bool {
+		report.Indexed++
+		seen[meta.ID] = true
+
+		stored, ok := locations[meta.ID]
+		if !ok {
+			report.MissingFromStorage = append(report.MissingFromStorage, meta.ID)
+			return true
+		}
+		if size, ok := sizes[meta.ID]; ok && size != meta.Size {
+			report.SizeMismatch = append(report.SizeMismatch, meta.ID)
+		}
+		if !sameLocations(stored, meta.Locations) {
+			report.LocationMismatch = append(report.LocationMismatch, meta.ID)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range locations {
+		if !seen[id] {
+			report.MissingFromIndex = append(report.MissingFromIndex, id)
+		}
+	}
+	sort.Strings(report.MissingFromIndex)
+
+	return report, nil
+}
+
+// storedLocations maps every stored ID to the tiers that hold it.
+func (s *ImageService) storedLocations() (map[string][]string, error) {
+	locations := make(map[string][]string)
+
+	ids, err := s.primary.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		locations[id] = append(locations[id], models.LocationPrimary)
+	}
+
+	if s.secondary != nil {
+		ids, err := s.secondary.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			locations[id] = append(locations[id], models.LocationSecondary)
+		}
+	}
+
+	return locations, nil
+}
+
+func buildMetadata(img *models.Image, location string, now time.Time) *models.Metadata {
+	sum := sha256.Sum256(img.Data)
+	meta := &models.Metadata{
+		ID:        img.ID,
+		Format:    img.Format,
+		Width:     img.Width,
+		Height:    img.Height,
+		Size:      int64(len(img.Data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if location != "" {
+		meta.Locations = []string{location}
+	}
+
+	if info, ok := animation.Probe(img.Data); ok {
+		meta.Width, meta.Height = info.Width, info.Height
+		meta.Frames = info.Frames
+		meta.DurationMS = info.Duration
+	}
+	if meta.Width == 0 || meta.Height == 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err == nil {
+			meta.Width = cfg.Width
+			meta.Height = cfg.Height
+		}
+	}
+	return meta
+}
+
+func sameLocations(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, l := range a {
+		found := false
+		for _, m := range b {
+			if l == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	maxTags           = 50
+	maxTagLength      = 64
+	maxTextLength     = 2000
+	maxAttributes     = 50
+	maxAttributeKey   = 64
+	maxAttributeValue = 1024
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// MetadataPatch describes a partial metadata update. Nil fields are left
+// unchanged; a nil attribute value removes that attribute.
+type MetadataPatch struct {
+	AltText    *string            `json:"alt_text"`
+	Caption    *string            `json:"caption"`
+	Tags       *[]string          `json:"tags"`
+	AddTags    []string           `json:"add_tags"`
+	RemoveTags []string           `json:"remove_tags"`
+	Attributes map[string]*string `json:"attributes"`
+}
+
+// UpdateMetadata applies a patch to the indexed metadata of an image without
+// touching its pixels. Images missing from the index are indexed first.
+func (s *ImageService) UpdateMetadata(id string, patch MetadataPatch) (*models.Metadata, error) {
+	if s.index == nil {
+		return nil, ErrIndexDisabled
+	}
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+
+	if _, err := s.index.Get(id); errors.Is(err, index.ErrNotFound) {
+		img, source, err := s.loadStored(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		if err := s.index.Put(buildMetadata(img, source, time.Now().UTC())); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.index.Update(id, func(meta *models.Metadata) error {
+		if patch.AltText != nil {
+			if len(*patch.AltText) > maxTextLength {
+				return fmt.Errorf("%w: alt_text exceeds %d characters", ErrInvalidMetadata, maxTextLength)
+			}
+			meta.AltText = *patch.AltText
+		}
+		if patch.Caption != nil {
+			if len(*patch.Caption) > maxTextLength {
+				return fmt.Errorf("%w: caption exceeds %d characters", ErrInvalidMetadata, maxTextLength)
+			}
+			meta.Caption = *patch.Caption
+		}
+
+		tags := meta.Tags
+		if patch.Tags != nil {
+			tags = nil
+			for _, tag := range *patch.Tags {
+				tags = appendTag(tags, tag)
+			}
+		}
+		for _, tag := range patch.AddTags {
+			tags = appendTag(tags, tag)
+		}
+		for _, tag := range patch.RemoveTags {
+			tags = removeTag(tags, normalizeTag(tag))
+		}
+		for _, tag := range tags {
+			if tag == "" || len(tag) > maxTagLength || strings.ContainsRune(tag, 0) {
+				return fmt.Errorf("%w: tags must be 1-%d characters", ErrInvalidMetadata, maxTagLength)
+			}
+		}
+		if len(tags) > maxTags {
+			return fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidMetadata, maxTags)
+		}
+		meta.Tags = tags
+
+		for key, value := range patch.Attributes {
+			if value == nil {
+				delete(meta.Attributes, key)
+				continue
+			}
+			if key == "" || len(key) > maxAttributeKey || len(*value) > maxAttributeValue {
+				return fmt.Errorf("%w: attribute keys must be 1-%d characters and values at most %d",
+					ErrInvalidMetadata, maxAttributeKey, maxAttributeValue)
+			}
+			if meta.Attributes == nil {
+				meta.Attributes = make(map[string]string)
+			}
+			meta.Attributes[key] = *value
+		}
+		if len(meta.Attributes) > maxAttributes {
+			return fmt.Errorf("%w: at most %d attributes are allowed", ErrInvalidMetadata, maxAttributes)
+		}
+
+		meta.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// normalizeTag trims and lowercases tags so queries are case-insensitive.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func appendTag(tags []string, tag string) []string {
+	tag = normalizeTag(tag)
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+func removeTag(tags []string, tag string) []string {
+	result := tags[:0:0]
+	for _, t := range tags {
+		if t != tag {
+			result = append(result, t)
+		}
+	}
+	return result
+}
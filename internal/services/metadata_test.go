@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/index"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+func newIndexedService(t *testing.T) *ImageService {
+	t.Helper()
+	fs, err := storage.NewFileSystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return &ImageService{primary: fs, index: idx}
+}
+
+func TestReindex(t *testing.T) {
+	s := newIndexedService(t)
+	for id, c := range map[string]color.Color{"kept": color.White, "new": color.Black} {
+		if err := s.primary.Save(losslessMaster(t, id, c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.index.PutMany([]*models.Metadata{
+		{ID: "kept", Size: 1, AltText: "A white square", Tags: []string{"white"}},
+		{ID: "gone", Tags: []string{"white"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := s.CheckIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Consistent() ||
+		!slices.Equal(check.MissingFromIndex, []string{"new"}) ||
+		!slices.Equal(check.MissingFromStorage, []string{"gone"}) ||
+		!slices.Equal(check.SizeMismatch, []string{"kept"}) {
+		t.Fatalf("CheckIndex = %+v", check)
+	}
+
+	report, err := s.Reindex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Indexed != 2 || report.Removed != 1 || len(report.Failed) != 0 {
+		t.Fatalf("Reindex = %+v", report)
+	}
+	if check, err := s.CheckIndex(); err != nil || !check.Consistent() {
+		t.Fatalf("CheckIndex after reindex = %+v, %v", check, err)
+	}
+
+	// Fields that only exist in the index survive the rebuild
+	meta, err := s.index.Get("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.AltText != "A white square" || !slices.Equal(meta.Tags, []string{"white"}) {
+		t.Errorf("user fields lost: %+v", meta)
+	}
+	if meta.Width != 16 || meta.PHash == "" || meta.Placeholder == nil {
+		t.Errorf("derived fields missing: %+v", meta)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestUpdateMetadata(t *testing.T) {
+	s := newIndexedService(t)
+	if err := s.primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Images missing from the index are indexed on their first update, and
+	// an extension in the ID is ignored
+	meta, err := s.UpdateMetadata("pic.webp", MetadataPatch{
+		AltText:    ptr("A white square"),
+		Tags:       &[]string{" Red ", "red", "BLUE"},
+		Attributes: map[string]*string{"camera": ptr("x100"), "lens": ptr("23mm")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "pic" || meta.Width != 16 || meta.AltText != "A white square" ||
+		!slices.Equal(meta.Tags, []string{"red", "blue"}) || len(meta.Attributes) != 2 {
+		t.Fatalf("UpdateMetadata = %+v", meta)
+	}
+
+	// Omitted fields are kept, nil attributes are removed
+	meta, err = s.UpdateMetadata("pic", MetadataPatch{
+		AddTags:    []string{"Green"},
+		RemoveTags: []string{"RED"},
+		Attributes: map[string]*string{"lens": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.AltText != "A white square" || !slices.Equal(meta.Tags, []string{"blue", "green"}) ||
+		len(meta.Attributes) != 1 || meta.Attributes["camera"] != "x100" {
+		t.Fatalf("UpdateMetadata = %+v", meta)
+	}
+
+	if _, err := s.UpdateMetadata("missing", MetadataPatch{AltText: ptr("x")}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing image: err = %v", err)
+	}
+	if _, err := (&ImageService{}).UpdateMetadata("pic", MetadataPatch{}); !errors.Is(err, ErrIndexDisabled) {
+		t.Errorf("no index: err = %v", err)
+	}
+}
+
+func TestUpdateMetadataValidation(t *testing.T) {
+	s := newIndexedService(t)
+	if err := s.primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateMetadata("pic", MetadataPatch{AltText: ptr("original"), Tags: &[]string{"keep"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	manyTags := make([]string, maxTags+1)
+	for i := range manyTags {
+		manyTags[i] = fmt.Sprintf("tag%d", i)
+	}
+	manyAttributes := map[string]*string{}
+	for i := 0; i <= maxAttributes; i++ {
+		manyAttributes[fmt.Sprintf("key%d", i)] = ptr("value")
+	}
+
+	tests := []struct {
+		name  string
+		patch MetadataPatch
+	}{
+		{"long alt text", MetadataPatch{AltText: ptr(strings.Repeat("a", maxTextLength+1))}},
+		{"long caption", MetadataPatch{Caption: ptr(strings.Repeat("a", maxTextLength+1))}},
+		{"empty tag", MetadataPatch{AddTags: []string{"  "}}},
+		{"long tag", MetadataPatch{Tags: &[]string{strings.Repeat("t", maxTagLength+1)}}},
+		{"NUL in tag", MetadataPatch{AddTags: []string{"a\x00b"}}},
+		{"too many tags", MetadataPatch{Tags: &manyTags}},
+		{"empty attribute key", MetadataPatch{Attributes: map[string]*string{"": ptr("v")}}},
+		{"long attribute key", MetadataPatch{Attributes: map[string]*string{strings.Repeat("k", maxAttributeKey+1): ptr("v")}}},
+		{"long attribute value", MetadataPatch{Attributes: map[string]*string{"k": ptr(strings.Repeat("v", maxAttributeValue+1))}}},
+		{"too many attributes", MetadataPatch{Attributes: manyAttributes}},
+		// A valid field does not get through alongside an invalid one
+		{"mixed", MetadataPatch{AltText: ptr("changed"), AddTags: []string{""}}},
+	}
+	for _, tt := range tests {
+		if _, err := s.UpdateMetadata("pic", tt.patch); !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%s: err = %v, want ErrInvalidMetadata", tt.name, err)
+		}
+	}
+
+	meta, err := s.index.Get("pic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.AltText != "original" || !slices.Equal(meta.Tags, []string{"keep"}) || len(meta.Attributes) != 0 {
+		t.Errorf("rejected patches changed the entry: %+v", meta)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/fs"
+	"log"
+
+	"github.com/kartex/imageprovider/internal/animation"
+)
+
+var ErrNoOriginal = errors.New("original not stored")
+
+// Original is an upload as it was received.
+type Original struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+// storeOriginal keeps the upload next to its master. When originals are not
+// kept, an original from a previous upload under the same ID is removed so
+// variants are never rendered from stale pixels.
+func (s *ImageService) storeOriginal(id string, data []byte) {
+	if s.ingest.keepOriginals {
+		err := s.primary.SaveOriginal(id, data)
+		if err == nil {
+			return
+		}
+		log.Printf("Warning: Failed to save original of %s: %v", id, err)
+	}
+	s.deleteOriginal(id)
+}
+
+func (s *ImageService) deleteOriginal(id string) {
+	if err := s.primary.DeleteOriginal(id); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Warning: Failed to delete original of %s: %v", id, err)
+	}
+	if s.secondary != nil {
+		if err := s.secondary.DeleteOriginal(id); err != nil {
+			log.Printf("Warning: Failed to delete original of %s from secondary storage: %v", id, err)
+		}
+	}
+}
+
+// GetOriginal returns the original upload of an image, looking in secondary
+// storage when it is not stored locally.
+func (s *ImageService) GetOriginal(id string) (*Original, error) {
+	data, err := s.primary.GetOriginal(id)
+	if err != nil && s.secondary != nil {
+		data, err = s.secondary.GetOriginal(id)
+	}
+	if err != nil || len(data) == 0 {
+		return nil, ErrNoOriginal
+	}
+
+	original := &Original{Data: data, ContentType: "application/octet-stream"}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		for _, f := range inputFormats {
+			if f.Format == format {
+				original.ContentType = f.MIMEType
+				original.Extension = f.Extensions[0]
+			}
+		}
+	}
+	return original, nil
+}
+
+// decodedOriginal is a still original in the same upright, color managed
+// form as its master.
+type decodedOriginal struct {
+	img  image.Image
+	info *uploadInfo
+	data []byte
+}
+
+// decodeOriginal decodes a still original so variants are rendered from the
+// best source available, even after the master has been re-encoded.
+// Animations are rendered from their master.
+func (s *ImageService) decodeOriginal(id string) (*decodedOriginal, bool) {
+	original, err := s.GetOriginal(id)
+	if err != nil || animation.IsAnimatedWebP(original.Data) {
+		return nil, false
+	}
+	if original.ContentType == "image/gif" && s.ingest.animate {
+		if n, err := animation.CountGIFFrames(original.Data); err != nil || n > 1 {
+			return nil, false
+		}
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(original.Data))
+	if err != nil {
+		log.Printf("Warning: Failed to decode original of %s: %v", id, err)
+		return nil, false
+	}
+	decoded, info := s.normalize(id, original.Data, decoded)
+	return &decodedOriginal{img: decoded, info: info, data: original.Data}, true
+}
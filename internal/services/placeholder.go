@@ -0,0 +1,35 @@
+package services
+
+import (
+	"image"
+
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/placeholder"
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+// placeholderPipeline renders the tiny preview served by GetPlaceholder.
+var placeholderPipeline = mustParse("rs:32:32/fr:1/q:40")
+
+func mustParse(chain string) *transform.Pipeline {
+	pipeline, err := transform.Parse(chain)
+	if err != nil {
+		panic(err)
+	}
+	return pipeline
+}
+
+func computePlaceholder(img image.Image) *models.Placeholder {
+	r := placeholder.Compute(img)
+	return &models.Placeholder{
+		BlurHash:      r.BlurHash,
+		ThumbHash:     r.ThumbHash,
+		DominantColor: r.DominantColor,
+	}
+}
+
+// GetPlaceholder returns a lossy WebP of at most 32x32 pixels, small enough
+// to inline as a data URI. Animations use their first frame.
+func (s *ImageService) GetPlaceholder(id string) (*Rendered, error) {
+	return s.Transform(id, placeholderPipeline)
+}
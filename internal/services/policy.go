@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kartex/imageprovider/internal/exif"
+)
+
+const defaultAspectTolerance = 0.01
+
+var (
+	ErrUnknownPolicy   = errors.New("unknown upload policy")
+	ErrPolicyViolation = errors.New("upload violates the validation policy")
+)
+
+// UploadPolicy is a named set of rules an upload must satisfy before it is
+// stored. Zero values leave a property unrestricted. Dimensions are checked
+// after EXIF orientation, as the image will be stored.
+type UploadPolicy struct {
+	Name            string   `json:"name"`
+	Formats         []string `json:"formats,omitempty"`
+	MinWidth        int      `json:"min_width,omitempty"`
+	MinHeight       int      `json:"min_height,omitempty"`
+	MaxWidth        int      `json:"max_width,omitempty"`
+	MaxHeight       int      `json:"max_height,omitempty"`
+	Aspect          string   `json:"aspect,omitempty"` // e.g. "3:1"
+	AspectTolerance float64  `json:"aspect_tolerance,omitempty"`
+	MinSize         int64    `json:"min_size,omitempty"` // bytes
+	MaxSize         int64    `json:"max_size,omitempty"` // bytes
+
+	ratio float64
+}
+
+// Violation describes one rule an upload broke.
+type Violation struct {
+	Rule     string `json:"rule"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// PolicyError lists every rule of a policy an upload violated. It matches
+// ErrPolicyViolation with errors.Is.
+type PolicyError struct {
+	Policy     string
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = fmt.Sprintf("%s (expected %s, got %s)", v.Rule, v.Expected, v.Actual)
+	}
+	return fmt.Sprintf("upload violates policy %q: %s", e.Policy, strings.Join(rules, "; "))
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// loadUploadPolicies reads UPLOAD_POLICIES, comma-separated name=rules pairs
+// where rules are written like a transformation chain, e.g.
+// avatar=formats:jpeg:png/min:256:256/aspect:1:1/max_size:5M. An invalid
+// definition stops the server, since running without the policies would
+// accept uploads they are meant to refuse.
+func loadUploadPolicies() map[string]*UploadPolicy {
+	policies, err := ParseUploadPolicies(os.Getenv("UPLOAD_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_POLICIES: %v", err)
+	}
+	return policies
+}
+
+// ParseUploadPolicies parses the UPLOAD_POLICIES format.
+func ParseUploadPolicies(s string) (map[string]*UploadPolicy, error) {
+	policies := map[string]*UploadPolicy{}
+	for _, def := range strings.Split(s, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		name, rules, ok := strings.Cut(def, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid policy %q (use name=rules)", def)
+		}
+		if _, dup := policies[name]; dup {
+			return nil, fmt.Errorf("duplicate policy %q", name)
+		}
+		policy, err := parsePolicyRules(name, rules)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", name, err)
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+func parsePolicyRules(name, rules string) (*UploadPolicy, error) {
+	p := &UploadPolicy{Name: name}
+	for _, rule := range strings.Split(rules, "/") {
+		args := strings.Split(strings.TrimSpace(rule), ":")
+		var err error
+		switch args[0] {
+		case "formats":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("formats needs at least one format")
+			}
+			for _, f := range args[1:] {
+				f = strings.ToLower(f)
+				if f == "jpg" {
+					f = "jpeg"
+				}
+				if !slices.ContainsFunc(inputFormats, func(info FormatInfo) bool { return info.Format == f }) {
+					return nil, fmt.Errorf("unsupported format %q", f)
+				}
+				p.Formats = append(p.Formats, f)
+			}
+		case "min":
+			p.MinWidth, p.MinHeight, err = parseDimensions(args)
+		case "max":
+			p.MaxWidth, p.MaxHeight, err = parseDimensions(args)
+		case "aspect":
+			err = p.parseAspect(args)
+		case "min_size":
+			p.MinSize, err = parseByteSize(args)
+		case "max_size":
+			p.MaxSize, err = parseByteSize(args)
+		default:
+			return nil, fmt.Errorf("unknown rule %q", args[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// parseDimensions reads min:W:H or max:W:H; 0 leaves a side unrestricted.
+func parseDimensions(args []string) (int, int, error) {
+	if len(args) != 3 {
+		return 0, 0, fmt.Errorf("%s needs width and height, e.g. %s:256:256", args[0], args[0])
+	}
+	w, werr := strconv.Atoi(args[1])
+	h, herr := strconv.Atoi(args[2])
+	if werr != nil || herr != nil || w < 0 || h < 0 {
+		return 0, 0, fmt.Errorf("invalid %s dimensions %s:%s", args[0], args[1], args[2])
+	}
+	return w, h, nil
+}
+
+// parseAspect reads aspect:W:H with an optional relative tolerance.
+func (p *UploadPolicy) parseAspect(args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("aspect needs a ratio, e.g. aspect:3:1 or aspect:3:1:0.02")
+	}
+	w, werr := strconv.ParseFloat(args[1], 64)
+	h, herr := strconv.ParseFloat(args[2], 64)
+	if werr != nil || herr != nil || w <= 0 || h <= 0 {
+		return fmt.Errorf("invalid aspect ratio %s:%s", args[1], args[2])
+	}
+	p.Aspect, p.ratio = args[1]+":"+args[2], w/h
+	p.AspectTolerance = defaultAspectTolerance
+	if len(args) == 4 {
+		t, err := strconv.ParseFloat(args[3], 64)
+		if err != nil || t < 0 || t >= 1 {
+			return fmt.Errorf("invalid aspect tolerance %q (use 0 to 0.99)", args[3])
+		}
+		p.AspectTolerance = t
+	}
+	return nil
+}
+
+// parseByteSize reads a size in bytes with an optional K, M or G suffix.
+func parseByteSize(args []string) (int64, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("%s needs a size, e.g. %s:5M", args[0], args[0])
+	}
+	v, unit := strings.ToUpper(args[1]), int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid %s %q", args[0], args[1])
+	}
+	return n * unit, nil
+}
+
+// UploadPolicies returns the configured policies sorted by name.
+func (s *ImageService) UploadPolicies() []*UploadPolicy {
+	policies := make([]*UploadPolicy, 0, len(s.policies))
+	for _, p := range s.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies
+}
+
+// UploadPolicy looks up a named policy.
+func (s *ImageService) UploadPolicy(name string) (*UploadPolicy, error) {
+	policy, ok := s.policies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPolicy, name)
+	}
+	return policy, nil
+}
+
+// check validates an upload against every rule and reports all violations
+// at once.
+func (p *UploadPolicy) check(data []byte, header *uploadHeader) error {
+	var violations []Violation
+	add := func(rule, expected, actual string) {
+		violations = append(violations, Violation{Rule: rule, Expected: expected, Actual: actual})
+	}
+
+	if len(p.Formats) > 0 && !slices.Contains(p.Formats, header.format) {
+		add("format", strings.Join(p.Formats, ", "), header.format)
+	}
+
+	// Sideways EXIF orientations are stored rotated, so check upright sizes
+	w, h := header.width, header.height
+	if raw, _ := exif.Extract(data); raw != nil {
+		if e, err := exif.Parse(raw); err == nil && e.Orientation >= 5 && e.Orientation <= 8 {
+			w, h = h, w
+		}
+	}
+	if p.MinWidth > 0 && w < p.MinWidth {
+		add("min_width", fmt.Sprintf(">= %d", p.MinWidth), strconv.Itoa(w))
+	}
+	if p.MinHeight > 0 && h < p.MinHeight {
+		add("min_height", fmt.Sprintf(">= %d", p.MinHeight), strconv.Itoa(h))
+	}
+	if p.MaxWidth > 0 && w > p.MaxWidth {
+		add("max_width", fmt.Sprintf("<= %d", p.MaxWidth), strconv.Itoa(w))
+	}
+	if p.MaxHeight > 0 && h > p.MaxHeight {
+		add("max_height", fmt.Sprintf("<= %d", p.MaxHeight), strconv.Itoa(h))
+	}
+	if p.ratio > 0 {
+		ratio := float64(w) / float64(h)
+		if math.Abs(ratio-p.ratio)/p.ratio > p.AspectTolerance {
+			add("aspect", fmt.Sprintf("%s (±%g%%)", p.Aspect, p.AspectTolerance*100), fmt.Sprintf("%dx%d (%.3g:1)", w, h, ratio))
+		}
+	}
+
+	size := int64(len(data))
+	if p.MinSize > 0 && size < p.MinSize {
+		add("min_size", fmt.Sprintf(">= %d bytes", p.MinSize), fmt.Sprintf("%d bytes", size))
+	}
+	if p.MaxSize > 0 && size > p.MaxSize {
+		add("max_size", fmt.Sprintf("<= %d bytes", p.MaxSize), fmt.Sprintf("%d bytes", size))
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Policy: p.Name, Violations: violations}
+	}
+	return nil
+}
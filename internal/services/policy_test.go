@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseUploadPolicies(t *testing.T) {
+	policies, err := ParseUploadPolicies(" Avatar=formats:jpg:PNG/min:256:256/aspect:1:1/max_size:5M , banner=max:0:600/aspect:3:1:0.05/min_size:10K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatar, banner := policies["avatar"], policies["banner"]
+	if avatar == nil || banner == nil {
+		t.Fatalf("policies = %v", policies)
+	}
+	if len(avatar.Formats) != 2 || avatar.Formats[0] != "jpeg" || avatar.Formats[1] != "png" ||
+		avatar.MinWidth != 256 || avatar.MinHeight != 256 || avatar.ratio != 1 ||
+		avatar.AspectTolerance != defaultAspectTolerance || avatar.MaxSize != 5<<20 {
+		t.Errorf("avatar = %+v", avatar)
+	}
+	if banner.MaxWidth != 0 || banner.MaxHeight != 600 || banner.ratio != 3 ||
+		banner.AspectTolerance != 0.05 || banner.MinSize != 10<<10 {
+		t.Errorf("banner = %+v", banner)
+	}
+
+	invalid := []string{
+		"avatar",
+		"=min:1:1",
+		"a=min:1:1,A=max:1:1",
+		"a=formats",
+		"a=formats:svg",
+		"a=min:256",
+		"a=max:-1:10",
+		"a=min:wide:10",
+		"a=aspect:3",
+		"a=aspect:0:1",
+		"a=aspect:3:1:1",
+		"a=aspect:3:1:-0.1",
+		"a=max_size",
+		"a=max_size:0",
+		"a=max_size:5T",
+		"a=min_size:-1K",
+		"a=max_size:9223372036854775807K",
+		"a=max_size:8589934592G",
+		"a=colors:3",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseUploadPolicies(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestUploadPolicyCheck(t *testing.T) {
+	policies, err := ParseUploadPolicies("banner=formats:jpeg:png/min:300:100/max:3000:1000/aspect:3:1/min_size:1K/max_size:4K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := policies["banner"]
+
+	tests := []struct {
+		name   string
+		header uploadHeader
+		size   int
+		rules  []string
+	}{
+		{"valid", uploadHeader{format: "png", width: 900, height: 300}, 2048, nil},
+		{"within aspect tolerance", uploadHeader{format: "jpeg", width: 902, height: 300}, 2048, nil},
+		{"wrong format", uploadHeader{format: "gif", width: 900, height: 300}, 2048, []string{"format"}},
+		{"too small", uploadHeader{format: "png", width: 150, height: 50}, 2048, []string{"min_width", "min_height"}},
+		{"too large", uploadHeader{format: "png", width: 6000, height: 2000}, 2048, []string{"max_width", "max_height"}},
+		{"wrong aspect", uploadHeader{format: "png", width: 600, height: 300}, 2048, []string{"aspect"}},
+		{"file too small", uploadHeader{format: "png", width: 900, height: 300}, 100, []string{"min_size"}},
+		{"everything", uploadHeader{format: "bmp", width: 5000, height: 50}, 5000, []string{"format", "min_height", "max_width", "aspect", "max_size"}},
+	}
+	for _, tt := range tests {
+		err := p.check(make([]byte, tt.size), &tt.header)
+		if tt.rules == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		var perr *PolicyError
+		if !errors.As(err, &perr) || !errors.Is(err, ErrPolicyViolation) || perr.Policy != "banner" {
+			t.Errorf("%s: err = %v, want a PolicyError", tt.name, err)
+			continue
+		}
+		var rules []string
+		for _, v := range perr.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if !slices.Equal(rules, tt.rules) {
+			t.Errorf("%s: violations = %v, want %v", tt.name, rules, tt.rules)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/kartex/imageprovider/internal/pool"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+const defaultProcessingQueueSize = 64
+
+// newProcessingPool reads PROCESSING_WORKERS (default: number of CPUs),
+// PROCESSING_BULK_WORKERS (default: half the workers) and
+// PROCESSING_QUEUE_SIZE (per lane).
+func newProcessingPool() *pool.Pool {
+	workers := runtime.NumCPU()
+	if v := os.Getenv("PROCESSING_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			workers = n
+		}
+	}
+
+	bulkWorkers := max(workers/2, 1)
+	if v := os.Getenv("PROCESSING_BULK_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			bulkWorkers = n
+		}
+	}
+
+	queueSize := defaultProcessingQueueSize
+	if v := os.Getenv("PROCESSING_QUEUE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			queueSize = n
+		}
+	}
+	return pool.New(workers, bulkWorkers, queueSize)
+}
+
+// Stats reports service-level counters. Storage is set when primary storage
+// deduplicates content.
+type Stats struct {
+	Processing pool.Stats          `json:"processing"`
+	Storage    *storage.DedupStats `json:"storage,omitempty"`
+}
+
+type dedupStorage interface {
+	DedupStats() (*storage.DedupStats, error)
+}
+
+func (s *ImageService) Stats() *Stats {
+	stats := &Stats{Processing: s.pool.Stats()}
+	if ds, ok := s.primary.(dedupStorage); ok {
+		dedup, err := ds.DedupStats()
+		if err != nil {
+			log.Printf("Warning: Failed to compute storage stats: %v", err)
+		}
+		stats.Storage = dedup
+	}
+	return stats
+}
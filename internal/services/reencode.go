@@ -0,0 +1,590 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chai2010/webp"
+	"github.com/kartex/imageprovider/internal/animation"
+	"github.com/kartex/imageprovider/internal/exif"
+	"github.com/kartex/imageprovider/internal/icc"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/pool"
+	"github.com/kartex/imageprovider/internal/storage"
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+const (
+	defaultReencodeRate      = 5 // images per second
+	defaultReencodeStatePath = "./reencode-job.json"
+	reencodePageSize         = 100
+	reencodeCheckpointEvery  = 20
+	maxRecentJobErrors       = 20
+)
+
+// Re-encode modes.
+const (
+	ReencodeMasters  = "masters"  // re-encode stored WebP masters
+	ReencodeVariants = "variants" // render presets and chains into the variant cache
+)
+
+// Job states.
+const (
+	JobRunning   = "running"
+	JobPaused    = "paused"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+var (
+	ErrJobActive      = errors.New("a re-encode job is already active")
+	ErrNoJob          = errors.New("no re-encode job")
+	ErrJobState       = errors.New("job is not in the required state")
+	ErrInvalidProfile = errors.New("invalid re-encode profile")
+)
+
+// ReencodeProfile describes the target of a re-encode job.
+type ReencodeProfile struct {
+	Mode string `json:"mode"`
+	// Quality for masters: 1-100 for lossy WebP, 0 for lossless.
+	Quality int `json:"quality,omitempty"`
+	// Presets and Chains are rendered in variants mode.
+	Presets []string `json:"presets,omitempty"`
+	Chains  []string `json:"chains,omitempty"`
+	// Prefix limits the job to IDs starting with it.
+	Prefix string `json:"prefix,omitempty"`
+	// Rate is the maximum number of images per second, 0 for REENCODE_RATE.
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// JobError records a failed image.
+type JobError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// JobStatus is the progress of a re-encode job. It is also the checkpoint
+// written to REENCODE_STATE_PATH, so a running job continues after a restart
+// from the image after Cursor.
+type JobStatus struct {
+	State      string          `json:"state"`
+	Profile    ReencodeProfile `json:"profile"`
+	Cursor     string          `json:"cursor,omitempty"`
+	Total      int             `json:"total,omitempty"`
+	Processed  int             `json:"processed"`
+	Skipped    int             `json:"skipped"`
+	Failed     int             `json:"failed"`
+	Errors     []JobError      `json:"errors,omitempty"`
+	LastError  string          `json:"last_error,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// reencodeJob holds the single background job. Only one job can be active
+// (running or paused) at a time.
+type reencodeJob struct {
+	mu        sync.Mutex
+	status    *JobStatus
+	statePath string
+	rate      float64
+	wake      chan struct{} // signals pause, resume and cancel to the runner
+	running   bool          // a runner goroutine exists
+}
+
+// newReencodeJob reads REENCODE_STATE_PATH and REENCODE_RATE and loads the
+// checkpoint of a previous job, if any.
+func newReencodeJob() *reencodeJob {
+	j := &reencodeJob{
+		statePath: os.Getenv("REENCODE_STATE_PATH"),
+		rate:      defaultReencodeRate,
+		wake:      make(chan struct{}, 1),
+	}
+	if j.statePath == "" {
+		j.statePath = defaultReencodeStatePath
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("REENCODE_RATE"), 64); err == nil && v > 0 {
+		j.rate = v
+	}
+
+	data, err := os.ReadFile(j.statePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: Failed to read re-encode checkpoint: %v", err)
+		}
+		return j
+	}
+	var status JobStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		log.Printf("Warning: Ignoring corrupt re-encode checkpoint %s: %v", j.statePath, err)
+		return j
+	}
+	j.status = &status
+	return j
+}
+
+// checkpoint writes the status atomically. Callers hold j.mu.
+func (j *reencodeJob) checkpoint() {
+	j.status.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(j.status, "", "  ")
+	if err != nil {
+		log.Printf("Warning: Failed to encode re-encode checkpoint: %v", err)
+		return
+	}
+	tmp := j.statePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(j.statePath), 0755); err == nil {
+		err = os.WriteFile(tmp, data, 0644)
+		if err == nil {
+			err = os.Rename(tmp, j.statePath)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to write re-encode checkpoint: %v", err)
+	}
+}
+
+func (j *reencodeJob) signal() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (j *reencodeJob) snapshot() *JobStatus {
+	status := *j.status
+	status.Errors = append([]JobError(nil), j.status.Errors...)
+	return &status
+}
+
+func (j *reencodeJob) active() bool {
+	return j.status != nil && (j.status.State == JobRunning || j.status.State == JobPaused)
+}
+
+// StartReencode validates the profile and starts a background job.
+func (s *ImageService) StartReencode(profile ReencodeProfile) (*JobStatus, error) {
+	if _, err := s.reencodeTargets(profile); err != nil {
+		return nil, err
+	}
+
+	j := s.reencode
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.active() {
+		return nil, ErrJobActive
+	}
+
+	now := time.Now().UTC()
+	j.status = &JobStatus{State: JobRunning, Profile: profile, StartedAt: now}
+	if s.index != nil && profile.Prefix == "" {
+		j.status.Total, _ = s.index.Count()
+	}
+	j.checkpoint()
+	s.startReencodeRunner()
+	return j.snapshot(), nil
+}
+
+// RestoreReencode restarts a job that was running when the process stopped.
+// Paused jobs stay paused until resumed through the API.
+func (s *ImageService) RestoreReencode() {
+	j := s.reencode
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == nil || j.status.State != JobRunning {
+		return
+	}
+	if _, err := s.reencodeTargets(j.status.Profile); err != nil {
+		// e.g. a preset was removed from the configuration
+		j.status.LastError = err.Error()
+		j.finish(JobFailed)
+		return
+	}
+	log.Printf("Resuming re-encode job after %q (%d processed)", j.status.Cursor, j.status.Processed)
+	s.startReencodeRunner()
+}
+
+// startReencodeRunner starts the runner unless one exists. Callers hold j.mu.
+func (s *ImageService) startReencodeRunner() {
+	if s.reencode.running {
+		s.reencode.signal()
+		return
+	}
+	s.reencode.running = true
+	go s.runReencode()
+}
+
+// ReencodeStatus returns the current or last job.
+func (s *ImageService) ReencodeStatus() (*JobStatus, error) {
+	j := s.reencode
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == nil {
+		return nil, ErrNoJob
+	}
+	return j.snapshot(), nil
+}
+
+// PauseReencode pauses a running job after the image in progress.
+func (s *ImageService) PauseReencode() (*JobStatus, error) {
+	return s.setReencodeState(JobRunning, JobPaused)
+}
+
+// ResumeReencode continues a paused job.
+func (s *ImageService) ResumeReencode() (*JobStatus, error) {
+	return s.setReencodeState(JobPaused, JobRunning)
+}
+
+// CancelReencode stops an active job. Its checkpoint is kept for reference.
+func (s *ImageService) CancelReencode() (*JobStatus, error) {
+	j := s.reencode
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == nil {
+		return nil, ErrNoJob
+	}
+	if !j.active() {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobState, j.status.State)
+	}
+	j.finish(JobCancelled)
+	return j.snapshot(), nil
+}
+
+func (s *ImageService) setReencodeState(from, to string) (*JobStatus, error) {
+	j := s.reencode
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == nil {
+		return nil, ErrNoJob
+	}
+	if j.status.State != from {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobState, j.status.State)
+	}
+	j.status.State = to
+	j.checkpoint()
+	if to == JobRunning {
+		s.startReencodeRunner()
+	} else {
+		j.signal()
+	}
+	return j.snapshot(), nil
+}
+
+// finish ends the job. Callers hold j.mu.
+func (j *reencodeJob) finish(state string) {
+	now := time.Now().UTC()
+	j.status.State = state
+	j.status.FinishedAt = &now
+	j.checkpoint()
+	j.signal()
+}
+
+// runReencode walks storage from the checkpoint cursor, one image at a time,
+// until the listing is exhausted or the job is paused, cancelled or replaced.
+// The decision to exit is made under the lock that guards running, so a
+// resume never finds a runner that is about to stop.
+func (s *ImageService) runReencode() {
+	j := s.reencode
+	var next time.Time
+	for {
+		j.mu.Lock()
+		status := j.status
+		if status.State != JobRunning {
+			j.running = false
+			j.mu.Unlock()
+			return
+		}
+		profile, cursor := status.Profile, status.Cursor
+		j.mu.Unlock()
+
+		targets, err := s.reencodeTargets(profile)
+		if err == nil {
+			var page *storage.Page
+			if page, err = s.primary.ListPage(storage.ListOptions{Prefix: profile.Prefix, Cursor: cursor, Limit: reencodePageSize}); err == nil {
+				if !s.reencodePage(status, page, targets, j.interval(profile), &next) || page.NextCursor != "" {
+					continue
+				}
+			}
+		}
+
+		j.mu.Lock()
+		if j.status == status && status.State == JobRunning {
+			if err != nil {
+				status.LastError = err.Error()
+				log.Printf("Warning: Re-encode job failed: %v", err)
+				j.finish(JobFailed)
+			} else {
+				j.finish(JobCompleted)
+			}
+		}
+		j.mu.Unlock()
+	}
+}
+
+func (j *reencodeJob) interval(profile ReencodeProfile) time.Duration {
+	rate := profile.Rate
+	if rate <= 0 {
+		rate = j.rate
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// reencodePage processes a page of the listing. It returns false when the
+// job stopped running before the end of the page.
+func (s *ImageService) reencodePage(status *JobStatus, page *storage.Page, targets *reencodeTargets, interval time.Duration, next *time.Time) bool {
+	j := s.reencode
+	for _, obj := range page.Objects {
+		// Throttle, waking early when the job is paused or cancelled
+		if wait := time.Until(*next); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-j.wake:
+			}
+		}
+		j.mu.Lock()
+		running := j.status == status && status.State == JobRunning
+		j.mu.Unlock()
+		if !running {
+			return false
+		}
+		*next = time.Now().Add(interval)
+
+		skipped, err := s.reencodeOne(obj.ID, targets)
+
+		j.mu.Lock()
+		if j.status == status && (status.State == JobRunning || status.State == JobPaused) {
+			status.Cursor = obj.ID
+			switch {
+			case err != nil:
+				status.Failed++
+				status.LastError = fmt.Sprintf("%s: %v", obj.ID, err)
+				status.Errors = append(status.Errors, JobError{ID: obj.ID, Error: err.Error()})
+				if len(status.Errors) > maxRecentJobErrors {
+					status.Errors = status.Errors[1:]
+				}
+			case skipped:
+				status.Skipped++
+			default:
+				status.Processed++
+			}
+			// Always record the last image before a pause takes effect
+			if done := status.Processed + status.Skipped + status.Failed; done%reencodeCheckpointEvery == 0 || status.State == JobPaused {
+				j.checkpoint()
+			}
+		}
+		j.mu.Unlock()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == status && status.State == JobRunning {
+		j.checkpoint()
+	}
+	return true
+}
+
+// reencodeTargets is a validated profile.
+type reencodeTargets struct {
+	mode      string
+	quality   int
+	pipelines []*transform.Pipeline
+}
+
+func (s *ImageService) reencodeTargets(profile ReencodeProfile) (*reencodeTargets, error) {
+	targets := &reencodeTargets{mode: profile.Mode, quality: profile.Quality}
+	if profile.Rate < 0 {
+		return nil, fmt.Errorf("%w: rate must not be negative", ErrInvalidProfile)
+	}
+
+	switch profile.Mode {
+	case ReencodeMasters:
+		if profile.Quality < 0 || profile.Quality > 100 {
+			return nil, fmt.Errorf("%w: quality must be 0 (lossless) to 100", ErrInvalidProfile)
+		}
+	case ReencodeVariants:
+		for _, name := range profile.Presets {
+			pipeline, err := s.Preset(name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+			}
+			targets.pipelines = append(targets.pipelines, pipeline)
+		}
+		for _, chain := range profile.Chains {
+			pipeline, err := transform.Parse(chain)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+			}
+			targets.pipelines = append(targets.pipelines, pipeline)
+		}
+		if len(targets.pipelines) == 0 {
+			return nil, fmt.Errorf("%w: variants mode needs presets or chains", ErrInvalidProfile)
+		}
+	default:
+		return nil, fmt.Errorf("%w: mode must be %s or %s", ErrInvalidProfile, ReencodeMasters, ReencodeVariants)
+	}
+	return targets, nil
+}
+
+// reencodeOne processes one image on the bulk lane, waiting while the
+// processing queue is full.
+func (s *ImageService) reencodeOne(id string, targets *reencodeTargets) (bool, error) {
+	for {
+		var skipped bool
+		var err error
+		if targets.mode == ReencodeMasters {
+			perr := s.pool.Do(pool.Bulk, func() { skipped, err = s.reencodeMaster(id, targets.quality) })
+			if err == nil {
+				err = perr
+			}
+		} else {
+			for _, pipeline := range targets.pipelines {
+				if _, err = s.transformOn(pool.Bulk, id, pipeline); err != nil {
+					break
+				}
+			}
+		}
+
+		var full *pool.QueueFullError
+		if !errors.As(err, &full) {
+			return skipped, err
+		}
+		time.Sleep(full.RetryAfter)
+	}
+}
+
+// reencodeMaster re-encodes a stored master at the given quality, from the
+// original when one is stored. Lossy masters without an original are skipped
+// to avoid generation loss, as are masters already in the target encoding.
+func (s *ImageService) reencodeMaster(id string, quality int) (bool, error) {
+	stored, err := s.primary.Get(id)
+	if err != nil {
+		return false, err
+	}
+	lossless := webpLossless(stored.Data)
+	if quality == 0 && lossless {
+		return true, nil
+	}
+
+	buf := new(bytes.Buffer)
+	opts := &webp.Options{Lossless: true}
+	if quality > 0 {
+		opts = &webp.Options{Quality: float32(quality)}
+	}
+
+	var encoded []byte
+	if animation.IsAnimatedWebP(stored.Data) {
+		if !lossless {
+			return true, nil
+		}
+		anim, err := animation.DecodeWebP(stored.Data)
+		if err != nil {
+			return false, err
+		}
+		if err := animation.EncodeWebP(buf, anim, quality); err != nil {
+			return false, err
+		}
+		encoded = buf.Bytes()
+	} else if original, ok := s.decodeOriginal(id); ok {
+		if err := webp.Encode(buf, original.img, opts); err != nil {
+			return false, err
+		}
+		if encoded, err = s.embedMetadata(buf.Bytes(), original.data, original.info.rawEXIF, original.info.profile); err != nil {
+			return false, err
+		}
+	} else {
+		if !lossless {
+			return true, nil
+		}
+		decoded, _, err := image.Decode(bytes.NewReader(stored.Data))
+		if err != nil {
+			return false, err
+		}
+		if err := webp.Encode(buf, decoded, opts); err != nil {
+			return false, err
+		}
+		// The master's metadata was already filtered at ingest; carry it over
+		rawEXIF, _ := exif.Extract(stored.Data)
+		profile, _ := icc.Extract(stored.Data)
+		if encoded, err = s.embedMetadata(buf.Bytes(), stored.Data, rawEXIF, profile); err != nil {
+			return false, err
+		}
+	}
+
+	// An upload or delete may have replaced the master while it was being
+	// encoded; never overwrite it with the old pixels
+	defer s.writes.lock(id)()
+	current, err := s.primary.Get(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !bytes.Equal(current.Data, stored.Data) {
+		return true, nil
+	}
+
+	img := &models.Image{ID: id, Data: encoded, Format: "webp", Width: stored.Width, Height: stored.Height}
+	if err := s.primary.Save(img); err != nil {
+		return false, err
+	}
+	s.replaceMaster(img)
+	return false, nil
+}
+
+// replaceMaster updates the cache and index after a master was rewritten.
+// Fields computed at ingest and user fields are kept.
+func (s *ImageService) replaceMaster(img *models.Image) {
+	s.uncache(img.ID)
+	s.dropVariants(img.ID)
+
+	if s.index == nil {
+		return
+	}
+	meta := buildMetadata(img, "", time.Now().UTC())
+	if old, err := s.index.Get(img.ID); err == nil {
+		meta.KeepIngestFields(old)
+		meta.KeepUserFields(old)
+		meta.Placeholder = old.Placeholder
+		meta.PHash = old.PHash
+		meta.Colors = old.Colors
+		meta.Locations = old.Locations
+	}
+	if err := s.index.Put(meta); err != nil {
+		log.Printf("Warning: Failed to index image %s: %v", img.ID, err)
+	}
+}
+
+// webpLossless reports whether the first image chunk of a WebP file is VP8L.
+// Animations are checked by their first frame.
+func webpLossless(data []byte) bool {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+	for pos := 12; pos+8 <= len(data); {
+		name := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		switch name {
+		case "VP8L":
+			return true
+		case "VP8 ":
+			return false
+		case "ANMF":
+			// Frame header is 16 bytes, followed by the frame's own chunks
+			pos += 8 + 16
+			continue
+		}
+		pos += 8 + size + size&1
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/chai2010/webp"
+	"github.com/kartex/imageprovider/internal/cache"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/storage"
+)
+
+// racingStorage stores a new master right after the first Get of id, as if
+// an upload landed while the image was being re-encoded.
+type racingStorage struct {
+	storage.Storage
+	id     string
+	upload *models.Image
+}
+
+func (r *racingStorage) Get(id string) (*models.Image, error) {
+	img, err := r.Storage.Get(id)
+	if id == r.id && r.upload != nil {
+		upload := r.upload
+		r.upload = nil
+		if err := r.Storage.Save(upload); err != nil {
+			return nil, err
+		}
+	}
+	return img, err
+}
+
+func losslessMaster(t *testing.T, id string, c color.Color) *models.Image {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b, _ := c.RGBA()
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), 255
+	}
+	buf := new(bytes.Buffer)
+	if err := webp.Encode(buf, img, &webp.Options{Lossless: true}); err != nil {
+		t.Fatal(err)
+	}
+	return &models.Image{ID: id, Data: buf.Bytes(), Format: "webp", Width: 16, Height: 16}
+}
+
+func newReencodeService(t *testing.T) (*ImageService, *racingStorage) {
+	t.Helper()
+	fs, err := storage.NewFileSystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := &racingStorage{Storage: fs}
+	return &ImageService{primary: primary, variants: cache.NewMemoryCache(10)}, primary
+}
+
+func TestReencodeMaster(t *testing.T) {
+	s, primary := newReencodeService(t)
+	if err := primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := s.reencodeMaster("pic", 80)
+	if err != nil || skipped {
+		t.Fatalf("reencodeMaster = %v, %v", skipped, err)
+	}
+	stored, err := primary.Get("pic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webpLossless(stored.Data) {
+		t.Fatal("master is still lossless")
+	}
+}
+
+func TestReencodeMasterKeepsConcurrentUpload(t *testing.T) {
+	s, primary := newReencodeService(t)
+	if err := primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+	upload := losslessMaster(t, "pic", color.Black)
+	primary.id, primary.upload = "pic", upload
+
+	skipped, err := s.reencodeMaster("pic", 80)
+	if err != nil || !skipped {
+		t.Fatalf("reencodeMaster = %v, %v, want skipped", skipped, err)
+	}
+	stored, err := primary.Get("pic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored.Data, upload.Data) {
+		t.Fatal("concurrent upload was overwritten")
+	}
+}
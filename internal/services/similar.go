@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kartex/imageprovider/internal/index"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/phash"
+	"github.com/kartex/imageprovider/internal/pool"
+)
+
+const (
+	defaultSimilarityThreshold = 10
+	MaxSimilarityThreshold     = 32
+	defaultSimilarLimit        = 20
+	MaxSimilarLimit            = 100
+)
+
+// Duplicate policies for uploads, selected with DUPLICATE_POLICY or per
+// upload.
+const (
+	DuplicatesAllow  = "allow"  // store without checking
+	DuplicatesFlag   = "flag"   // store and record the near-duplicates found
+	DuplicatesReject = "reject" // refuse uploads with near-duplicates
+)
+
+var ErrNearDuplicate = errors.New("image is a near-duplicate of an existing image")
+
+// SimilarImage is a match from a perceptual hash search.
+type SimilarImage struct {
+	ID       string `json:"id"`
+	Distance int    `json:"distance"`
+	PHash    string `json:"phash"`
+}
+
+// DuplicateError is returned for rejected uploads. It matches
+// ErrNearDuplicate with errors.Is.
+type DuplicateError struct {
+	Matches []SimilarImage
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%s %q (distance %d)", ErrNearDuplicate, e.Matches[0].ID, e.Matches[0].Distance)
+}
+
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrNearDuplicate
+}
+
+// FindSimilar returns images whose perceptual hash is within threshold bits
+// of the image's, closest first. It requires the metadata index.
+func (s *ImageService) FindSimilar(id string, threshold, limit int) (*models.Metadata, []SimilarImage, error) {
+	if s.index == nil {
+		return nil, nil, ErrIndexDisabled
+	}
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+	meta, err := s.index.Get(id)
+	if errors.Is(err, index.ErrNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Images indexed before hashes were computed get one on first use
+	if meta.PHash == "" {
+		var hash string
+		if perr := s.pool.Do(pool.Interactive, func() {
+			var decoded image.Image
+			if decoded, err = s.decodeOnWorker(id); err == nil {
+				hash = phash.Compute(decoded).String()
+			}
+		}); perr != nil {
+			return nil, nil, perr
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		meta, err = s.index.Update(id, func(m *models.Metadata) error {
+			m.PHash = hash
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	hash, err := phash.Parse(meta.PHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if threshold <= 0 {
+		threshold = s.ingest.similarityThreshold
+	}
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
+	return meta, s.findSimilar(hash, id, min(threshold, MaxSimilarityThreshold), min(limit, MaxSimilarLimit)), nil
+}
+
+// findSimilar looks up hashes within threshold of hash in the index's
+// in-memory hash table.
+func (s *ImageService) findSimilar(hash phash.Hash, exclude string, threshold, limit int) []SimilarImage {
+	matches := []SimilarImage{}
+	for _, m := range s.index.Similar(hash, threshold, exclude) {
+		matches = append(matches, SimilarImage{ID: m.ID, Distance: m.Distance, PHash: m.Hash.String()})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// checkDuplicates applies the upload's duplicate policy. It returns the
+// near-duplicates to flag, or a *DuplicateError when they must be rejected.
+// Without the index nothing can be checked and the upload proceeds.
+func (s *ImageService) checkDuplicates(id string, hash phash.Hash, opts UploadOptions) ([]string, error) {
+	policy, threshold := opts.Duplicates, opts.DuplicateThreshold
+	if policy == "" {
+		policy = s.ingest.duplicates
+	}
+	if policy == DuplicatesAllow || s.index == nil {
+		return nil, nil
+	}
+	if threshold <= 0 {
+		threshold = s.ingest.similarityThreshold
+	}
+
+	matches := s.findSimilar(hash, id, min(threshold, MaxSimilarityThreshold), MaxSimilarLimit)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	if policy == DuplicatesReject {
+		return nil, &DuplicateError{Matches: matches}
+	}
+
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/models"
+)
+
+func TestFindSimilar(t *testing.T) {
+	s := newIndexedService(t)
+	err := s.index.PutMany([]*models.Metadata{
+		{ID: "pic", PHash: "00000000000000ff"},
+		{ID: "near", PHash: "00000000000000fe"},
+		{ID: "nearer", PHash: "00000000000000ff"},
+		{ID: "far", PHash: "ffffffffffffff00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The extension of a file name is ignored, as everywhere else
+	meta, matches, err := s.FindSimilar("pic.webp", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "pic" || len(matches) != 2 ||
+		matches[0] != (SimilarImage{ID: "nearer", Distance: 0, PHash: "00000000000000ff"}) ||
+		matches[1].ID != "near" || matches[1].Distance != 1 {
+		t.Fatalf("FindSimilar = %+v, %+v", meta, matches)
+	}
+
+	if _, matches, _ := s.FindSimilar("pic", 10, 1); len(matches) != 1 {
+		t.Errorf("limit 1 returned %d matches", len(matches))
+	}
+	if _, _, err := s.FindSimilar("missing.png", 10, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing image: err = %v", err)
+	}
+}
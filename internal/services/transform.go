@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kartex/imageprovider/internal/animation"
+	"github.com/kartex/imageprovider/internal/cache"
+	"github.com/kartex/imageprovider/internal/pool"
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+const (
+	defaultVariantCacheSize    = 500
+	defaultVariantCacheMB      = 256
+	defaultVariantCacheEntryMB = 16
+)
+
+var ErrUnknownPreset = errors.New("unknown preset")
+
+// Rendered is an encoded transformation result.
+type Rendered struct {
+	Data        []byte
+	ContentType string
+	// Key identifies the variant: the image ID and canonical chain
+	Key string
+}
+
+func newVariantCache() *cache.MemoryCache {
+	size := defaultVariantCacheSize
+	if v := os.Getenv("VARIANT_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			size = n
+		}
+	}
+
+	maxMB := defaultVariantCacheMB
+	if v := os.Getenv("VARIANT_CACHE_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxMB = n
+		}
+	}
+
+	entryMB := defaultVariantCacheEntryMB
+	if v := os.Getenv("VARIANT_CACHE_MAX_ENTRY_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			entryMB = n
+		}
+	}
+	return cache.NewBoundedMemoryCache(size, int64(maxMB)*1024*1024, int64(entryMB)*1024*1024)
+}
+
+// loadPresets reads TRANSFORM_PRESETS. An invalid definition disables all
+// presets rather than serving a partial set.
+func loadPresets() transform.Presets {
+	presets, err := transform.ParsePresets(os.Getenv("TRANSFORM_PRESETS"))
+	if err != nil {
+		log.Printf("Warning: Ignoring TRANSFORM_PRESETS: %v", err)
+		return transform.Presets{}
+	}
+	return presets
+}
+
+// Presets returns the configured named presets.
+func (s *ImageService) Presets() transform.Presets {
+	return s.presets
+}
+
+// Preset looks up a named preset.
+func (s *ImageService) Preset(name string) (*transform.Pipeline, error) {
+	pipeline, ok := s.presets.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPreset, name)
+	}
+	return pipeline, nil
+}
+
+// Transform renders an image through a parsed pipeline. Results are cached
+// under the canonical chain, so equivalent chains share one cache entry.
+func (s *ImageService) Transform(id string, pipeline *transform.Pipeline) (*Rendered, error) {
+	return s.transformOn(pool.Interactive, id, pipeline)
+}
+
+// transformOn renders on the given lane of the processing pool; background
+// work uses the bulk lane.
+func (s *ImageService) transformOn(priority pool.Priority, id string, pipeline *transform.Pipeline) (*Rendered, error) {
+	format := pipeline.OutputFormat()
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+	key := id + "/" + pipeline.Canonical()
+	if data, ok := s.variants.Get(key); ok {
+		return &Rendered{Data: data, ContentType: transform.ContentType(format), Key: key}, nil
+	}
+
+	// Cache hits above never queue
+	var rendered *Rendered
+	var err error
+	if perr := s.pool.Do(priority, func() { rendered, err = s.render(id, key, pipeline) }); perr != nil {
+		return nil, perr
+	}
+	return rendered, err
+}
+
+func (s *ImageService) render(id, key string, pipeline *transform.Pipeline) (*Rendered, error) {
+	// Taken before any input is read: if the image or a watermark is replaced
+	// while rendering, the result is returned but not cached
+	generation := s.variants.Generation()
+
+	format := pipeline.OutputFormat()
+	pipeline, err := pipeline.Bind(s.loadOverlay)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if original, ok := s.decodeOriginal(id); ok {
+		if err := transform.Encode(buf, pipeline.Apply(original.img), format, pipeline.Quality); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+	} else if err := s.renderMaster(buf, id, pipeline); err != nil {
+		return nil, err
+	}
+
+	s.variants.SetIfGeneration(key, buf.Bytes(), generation)
+	return &Rendered{Data: buf.Bytes(), ContentType: transform.ContentType(format), Key: key}, nil
+}
+
+// renderMaster renders from the stored WebP, used when no still original is
+// available.
+func (s *ImageService) renderMaster(w io.Writer, id string, pipeline *transform.Pipeline) error {
+	img, err := s.getImageOnWorker(id)
+	if err != nil {
+		return err
+	}
+
+	if animation.IsAnimatedWebP(img.Data) {
+		err = renderAnimation(w, img.Data, pipeline)
+	} else {
+		var decoded image.Image
+		if decoded, _, err = image.Decode(bytes.NewReader(img.Data)); err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+		err = transform.Encode(w, pipeline.Apply(decoded), pipeline.OutputFormat(), pipeline.Quality)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	return nil
+}
+
+// renderAnimation applies the pipeline to every frame, or to the selected
+// frame when the pipeline asks for one or its format cannot animate.
+func renderAnimation(w io.Writer, data []byte, pipeline *transform.Pipeline) error {
+	anim, err := animation.DecodeWebP(data)
+	if err != nil {
+		return err
+	}
+
+	if !pipeline.Animated() {
+		n := min(max(pipeline.Frame, 1), len(anim.Frames))
+		return transform.Encode(w, pipeline.Apply(anim.Frames[n-1].Image), pipeline.OutputFormat(), pipeline.Quality)
+	}
+
+	anim = anim.Map(pipeline.Apply)
+	if pipeline.OutputFormat() == "gif" {
+		return animation.EncodeGIF(w, anim)
+	}
+	return animation.EncodeWebP(w, anim, pipeline.Quality)
+}
+
+// decodeStill decodes stored image data; animations yield their first frame.
+func decodeStill(data []byte) (image.Image, error) {
+	if animation.IsAnimatedWebP(data) {
+		anim, err := animation.DecodeWebP(data)
+		if err != nil {
+			return nil, err
+		}
+		return anim.Frames[0].Image, nil
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	return decoded, err
+}
+
+// dropVariants removes the cached variants of an image and every variant
+// that uses it as a watermark, whose key holds the chain's wm:<id>: step.
+func (s *ImageService) dropVariants(id string) {
+	s.variants.DeletePrefix(id + "/")
+	s.variants.DeleteFunc(func(key string) bool {
+		return strings.Contains(key, "/wm:"+id+":")
+	})
+}
+
+// decodeOnWorker fetches and decodes a stored image from a pool worker.
+func (s *ImageService) decodeOnWorker(id string) (image.Image, error) {
+	img, err := s.getImageOnWorker(id)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeStill(img.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return decoded, nil
+}
+
+// loadOverlay resolves watermark references. Watermarks are ordinary stored
+// images, uploaded and deleted through the usual endpoints.
+func (s *ImageService) loadOverlay(id string) (image.Image, error) {
+	img, err := s.getImageOnWorker(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return decodeStill(img.Data)
+}
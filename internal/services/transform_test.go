@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/png"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/cache"
+	"github.com/kartex/imageprovider/internal/models"
+	"github.com/kartex/imageprovider/internal/storage"
+	"github.com/kartex/imageprovider/internal/transform"
+)
+
+// hookStorage runs afterGet once, right after the next Get has read a master.
+type hookStorage struct {
+	storage.Storage
+	afterGet func()
+}
+
+func (h *hookStorage) Get(id string) (*models.Image, error) {
+	img, err := h.Storage.Get(id)
+	if f := h.afterGet; f != nil {
+		h.afterGet = nil
+		f()
+	}
+	return img, err
+}
+
+func newTransformService(t *testing.T) (*ImageService, *hookStorage) {
+	t.Helper()
+	fs, err := storage.NewFileSystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := &hookStorage{Storage: fs}
+	return &ImageService{primary: primary, variants: cache.NewMemoryCache(10), pool: newProcessingPool(), maxSize: 10}, primary
+}
+
+func renderedColor(t *testing.T, r *Rendered) color.Color {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(r.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.At(0, 0)
+}
+
+func TestTransformNormalizesID(t *testing.T) {
+	s, primary := newTransformService(t)
+	if err := primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := transform.Parse("f:png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.Transform("pic.webp", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Transform("pic", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Key != "pic/f:png" || b.Key != a.Key {
+		t.Fatalf("keys = %q and %q", a.Key, b.Key)
+	}
+
+	// Replacing the image drops the variant under either spelling
+	if err := s.storeIngested(losslessMaster(t, "pic", color.Black), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.variants.Get(a.Key); ok {
+		t.Error("variant kept after replacing the image")
+	}
+}
+
+func TestTransformSkipsCachingStaleRender(t *testing.T) {
+	s, primary := newTransformService(t)
+	if err := primary.Save(losslessMaster(t, "pic", color.White)); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := transform.Parse("f:png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new upload lands after the render has read the old master
+	primary.afterGet = func() {
+		if err := s.storeIngested(losslessMaster(t, "pic", color.Black), nil); err != nil {
+			t.Error(err)
+		}
+	}
+	rendered, err := s.Transform("pic", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := renderedColor(t, rendered).RGBA(); r == 0 {
+		t.Fatal("render did not read the old master")
+	}
+	if _, ok := s.variants.Get(rendered.Key); ok {
+		t.Fatal("variant of the replaced master was cached")
+	}
+
+	rendered, err = s.Transform("pic", pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := renderedColor(t, rendered).RGBA(); r != 0 {
+		t.Error("render after the upload shows the old master")
+	}
+}
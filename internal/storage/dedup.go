@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir holds content-addressed files. Stored images and originals are
+// hard links to these blobs, so identical content is kept on disk once and
+// a blob's link count, minus its own entry, is its reference count.
+const blobsDir = "blobs"
+
+// DedupStats summarises content-addressed storage.
+type DedupStats struct {
+	Blobs        int   `json:"blobs"`
+	References   int   `json:"references"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	LogicalBytes int64 `json:"logical_bytes"`
+	SavedBytes   int64 `json:"saved_bytes"`
+}
+
+func (s *FileSystemStorage) blobPath(sum string) string {
+	return filepath.Join(s.baseDir, blobsDir, sum[0:2], sum[2:4], sum)
+}
+
+// writeFile stores data at path, as a link to its blob when deduplication is
+// enabled. Files are always replaced by renaming a new file over them, never
+// rewritten in place: a file stored while deduplication was on may still be a
+// link to a blob shared with other images. Replacing a file releases the
+// blob it pointed to.
+func (s *FileSystemStorage) writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dedup {
+		old := s.blobOf(path)
+		if err := writeAtomic(path, data); err != nil {
+			return err
+		}
+		s.releaseBlob(old)
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	blob := s.blobPath(hex.EncodeToString(sum[:]))
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		if err := writeAtomic(blob, data); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	old := s.blobOf(path)
+	if old == blob {
+		return nil
+	}
+
+	// Link under a temporary name and rename, so readers never see a
+	// missing file while it is replaced
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.releaseBlob(old)
+	return nil
+}
+
+// removeFile deletes path and, when it was the last reference, its blob.
+func (s *FileSystemStorage) removeFile(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := s.blobOf(path)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.releaseBlob(blob)
+	return nil
+}
+
+// blobOf returns the blob path links to, or "" for a plain file.
+func (s *FileSystemStorage) blobOf(path string) string {
+	if n, ok := linkCount(path); !ok || n < 2 {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	blob := s.blobPath(hex.EncodeToString(sum[:]))
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	bi, err := os.Stat(blob)
+	if err != nil || !os.SameFile(fi, bi) {
+		return ""
+	}
+	return blob
+}
+
+// releaseBlob removes a blob that is no longer referenced.
+func (s *FileSystemStorage) releaseBlob(blob string) {
+	if blob == "" {
+		return
+	}
+	if n, ok := linkCount(blob); ok && n <= 1 {
+		os.Remove(blob)
+	}
+}
+
+// DedupStats walks the blob directory. References count stored images and
+// originals sharing each blob.
+func (s *FileSystemStorage) DedupStats() (*DedupStats, error) {
+	stats := &DedupStats{}
+	if !s.dedup {
+		return stats, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root := filepath.Join(s.baseDir, blobsDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		n, _ := linkCount(path)
+		refs := max(n-1, 0)
+		stats.Blobs++
+		stats.References += refs
+		stats.StoredBytes += info.Size()
+		stats.LogicalBytes += info.Size() * int64(refs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.SavedBytes = max(stats.LogicalBytes-stats.StoredBytes, 0)
+	return stats, nil
+}
+
+// writeAtomic writes data to a new file and renames it over path, so
+// readers see either the old or the new content and other links to the old
+// file are left untouched.
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
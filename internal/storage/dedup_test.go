@@ -0,0 +1,60 @@
+//go:build unix
+
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kartex/imageprovider/internal/models"
+)
+
+func TestWriteWithoutDedupKeepsSharedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileSystemStorage{baseDir: dir, dedup: true}
+
+	shared := []byte("shared content")
+	for _, id := range []string{"aa", "bb"} {
+		if err := s.Save(&models.Image{ID: id, Data: shared}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats, _ := s.DedupStats(); stats.Blobs != 1 || stats.References != 2 {
+		t.Fatalf("stats = %+v, want one blob with two references", stats)
+	}
+
+	// Turning deduplication off must not rewrite the shared inode
+	s.dedup = false
+	if err := s.Save(&models.Image{ID: "aa", Data: []byte("new content")}); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string][]byte{"aa": []byte("new content"), "bb": shared} {
+		img, err := s.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(img.Data, want) {
+			t.Errorf("%s = %q, want %q", id, img.Data, want)
+		}
+	}
+
+	// Deleting the last reference releases the blob
+	if err := s.Delete("bb"); err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(dir, blobsDir, "*", "*", "*"))
+	if len(blobs) != 0 {
+		t.Errorf("blobs left after deleting every reference: %v", blobs)
+	}
+
+	// No temporary files are left behind
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(tmps) != 0 {
+		t.Errorf("temporary files left: %v", tmps)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "aa.webp")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+// Link counts are not available, so files are always stored in place.
+const dedupSupported = false
+
+func linkCount(path string) (int, bool) {
+	return 0, false
+}
@@ -3,9 +3,12 @@ package storage
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/kartex/imageprovider/internal/models"
 	"github.com/minio/minio-go/v7"
@@ -68,6 +71,9 @@ func (s *S3Storage) Get(id string) (*models.Image, error) {
 	defer object.Close()
 
 	data, err := io.ReadAll(object)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +90,30 @@ func (s *S3Storage) Delete(id string) error {
 	return s.client.RemoveObject(ctx, s.bucket, id+".webp", minio.RemoveObjectOptions{})
 }
 
+func (s *S3Storage) SaveOriginal(id string, data []byte) error {
+	ctx := context.Background()
+	_, err := s.client.PutObject(ctx, s.bucket, originalsDir+"/"+id, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: http.DetectContentType(data),
+	})
+	return err
+}
+
+func (s *S3Storage) GetOriginal(id string) ([]byte, error) {
+	ctx := context.Background()
+	object, err := s.client.GetObject(ctx, s.bucket, originalsDir+"/"+id, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return io.ReadAll(object)
+}
+
+func (s *S3Storage) DeleteOriginal(id string) error {
+	ctx := context.Background()
+	return s.client.RemoveObject(ctx, s.bucket, originalsDir+"/"+id, minio.RemoveObjectOptions{})
+}
+
 func (s *S3Storage) List() ([]string, error) {
 	ctx := context.Background()
 	var ids []string
@@ -102,3 +132,44 @@ func (s *S3Storage) List() ([]string, error) {
 
 	return ids, nil
 }
+
+// ListPage returns objects in key order, which differs from ID order where one
+// ID is a prefix of another: "a-b.webp" sorts before "a.webp".
+func (s *S3Storage) ListPage(opts ListOptions) (*Page, error) {
+	limit := opts.limit()
+
+	// Cancel the listing once the page is full so minio stops fetching
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listOpts := minio.ListObjectsOptions{
+		Prefix:    opts.Prefix,
+		Recursive: true,
+		MaxKeys:   limit + 1,
+	}
+	if opts.Cursor != "" {
+		// minio maps StartAfter onto the ListObjectsV2 continuation
+		listOpts.StartAfter = opts.Cursor + ".webp"
+	}
+
+	page := &Page{}
+	for object := range s.client.ListObjects(ctx, s.bucket, listOpts) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if filepath.Ext(object.Key) != ".webp" {
+			continue
+		}
+		if len(page.Objects) == limit {
+			page.NextCursor = page.Objects[limit-1].ID
+			break
+		}
+		page.Objects = append(page.Objects, ObjectInfo{
+			ID:      strings.TrimSuffix(object.Key, ".webp"),
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+
+	return page, nil
+}
@@ -1,29 +1,96 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kartex/imageprovider/internal/models"
 )
 
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrNotFound is returned by Get when no image is stored under the ID.
+var ErrNotFound = errors.New("image not found in storage")
+
 type Storage interface {
 	Save(image *models.Image) error
 	Get(id string) (*models.Image, error)
 	Delete(id string) error
 	List() ([]string, error)
+	// ListPage returns stored objects in the backend's listing order, starting
+	// after opts.Cursor. The order is stable across pages but is not plain ID
+	// order: the filesystem compares path elements and S3 compares object
+	// keys including the .webp suffix.
+	ListPage(opts ListOptions) (*Page, error)
+
+	// Original uploads are kept apart from the WebP masters and are not
+	// included in listings.
+	SaveOriginal(id string, data []byte) error
+	GetOriginal(id string) ([]byte, error)
+	DeleteOriginal(id string) error
+}
+
+// originalsDir holds original uploads. Its name, like blobsDir, is longer
+// than two characters, so it cannot collide with an ID directory.
+const originalsDir = "originals"
+
+// ObjectInfo describes a stored image without loading its data.
+type ObjectInfo struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+}
+
+// ListOptions controls a paginated listing. Cursor is the opaque NextCursor
+// of the previous page; an empty cursor starts from the beginning.
+type ListOptions struct {
+	Prefix string
+	Cursor string
+	Limit  int
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page struct {
+	Objects    []ObjectInfo
+	NextCursor string
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return o.Limit
 }
 
 type FileSystemStorage struct {
 	baseDir string
+	dedup   bool
+	// mu serialises writes and deletes so blob reference counts stay exact
+	mu sync.Mutex
 }
 
+// NewFileSystemStorage stores files under baseDir. Identical content is
+// stored once (see dedup.go) unless STORAGE_DEDUP=false.
 func NewFileSystemStorage(baseDir string) (*FileSystemStorage, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, err
 	}
-	return &FileSystemStorage{baseDir: baseDir}, nil
+	return &FileSystemStorage{
+		baseDir: baseDir,
+		dedup:   dedupSupported && os.Getenv("STORAGE_DEDUP") != "false",
+	}, nil
 }
 
 func (s *FileSystemStorage) getPath(id string) string {
@@ -50,20 +117,23 @@ func (s *FileSystemStorage) getPath(id string) string {
 	return filepath.Join(append([]string{s.baseDir}, append(parts, filename)...)...)
 }
 
-func (s *FileSystemStorage) Save(image *models.Image) error {
-	path := s.getPath(image.ID)
-
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return err
-	}
+// getOriginalPath mirrors the master layout under the originals directory,
+// e.g. "123456" -> "originals/12/34/56.orig".
+func (s *FileSystemStorage) getOriginalPath(id string) string {
+	rel, _ := filepath.Rel(s.baseDir, s.getPath(id))
+	return filepath.Join(s.baseDir, originalsDir, strings.TrimSuffix(rel, ".webp")+".orig")
+}
 
-	return os.WriteFile(path, image.Data, 0644)
+func (s *FileSystemStorage) Save(image *models.Image) error {
+	return s.writeFile(s.getPath(image.ID), image.Data)
 }
 
 func (s *FileSystemStorage) Get(id string) (*models.Image, error) {
 	path := s.getPath(id)
 	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -76,8 +146,25 @@ func (s *FileSystemStorage) Get(id string) (*models.Image, error) {
 }
 
 func (s *FileSystemStorage) Delete(id string) error {
-	path := s.getPath(id)
-	return os.Remove(path)
+	return s.removeFile(s.getPath(id))
+}
+
+func (s *FileSystemStorage) SaveOriginal(id string, data []byte) error {
+	return s.writeFile(s.getOriginalPath(id), data)
+}
+
+func (s *FileSystemStorage) GetOriginal(id string) ([]byte, error) {
+	return os.ReadFile(s.getOriginalPath(id))
+}
+
+func (s *FileSystemStorage) DeleteOriginal(id string) error {
+	return s.removeFile(s.getOriginalPath(id))
+}
+
+// isReservedDir reports whether path holds originals or blobs rather than
+// stored images.
+func (s *FileSystemStorage) isReservedDir(path string) bool {
+	return path == filepath.Join(s.baseDir, originalsDir) || path == filepath.Join(s.baseDir, blobsDir)
 }
 
 func (s *FileSystemStorage) List() ([]string, error) {
@@ -86,6 +173,9 @@ func (s *FileSystemStorage) List() ([]string, error) {
 		if err != nil {
 			return err
 		}
+		if info.IsDir() && s.isReservedDir(path) {
+			return filepath.SkipDir
+		}
 		if !info.IsDir() && strings.HasSuffix(path, ".webp") {
 			// Convert path back to ID
 			relPath, err := filepath.Rel(s.baseDir, path)
@@ -101,3 +191,105 @@ func (s *FileSystemStorage) List() ([]string, error) {
 
 	return ids, err
 }
+
+func (s *FileSystemStorage) ListPage(opts ListOptions) (*Page, error) {
+	limit := opts.limit()
+
+	// The walk visits entries in lexical order of their path elements, so the
+	// cursor is compared element by element against the same layout.
+	var cursor []string
+	if opts.Cursor != "" {
+		rel, err := filepath.Rel(s.baseDir, s.getPath(opts.Cursor))
+		if err != nil {
+			return nil, err
+		}
+		cursor = strings.Split(rel, string(filepath.Separator))
+	}
+
+	page := &Page{}
+	err := filepath.WalkDir(s.baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.baseDir {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.baseDir, path)
+		if err != nil {
+			return err
+		}
+		elems := strings.Split(relPath, string(filepath.Separator))
+		idPart := strings.Join(elems, "")
+
+		if d.IsDir() {
+			if s.isReservedDir(path) {
+				return filepath.SkipDir
+			}
+			// Skip directories that sort entirely before the cursor or that
+			// cannot contain IDs with the requested prefix
+			if cursor != nil && !hasElemPrefix(cursor, elems) && compareElems(elems, cursor) < 0 {
+				return filepath.SkipDir
+			}
+			if !strings.HasPrefix(idPart, opts.Prefix) && !strings.HasPrefix(opts.Prefix, idPart) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".webp") {
+			return nil
+		}
+		if cursor != nil && compareElems(elems, cursor) <= 0 {
+			return nil
+		}
+
+		id := strings.TrimSuffix(idPart, ".webp")
+		if !strings.HasPrefix(id, opts.Prefix) {
+			return nil
+		}
+
+		if len(page.Objects) == limit {
+			page.NextCursor = page.Objects[limit-1].ID
+			return filepath.SkipAll
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		page.Objects = append(page.Objects, ObjectInfo{
+			ID:      id,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// compareElems compares two paths element by element, matching walk order.
+func compareElems(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func hasElemPrefix(elems, prefix []string) bool {
+	if len(prefix) > len(elems) {
+		return false
+	}
+	for i := range prefix {
+		if elems[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+// Package testutil builds crafted image headers shared by tests.
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// PNGHeader is a PNG signature and IHDR chunk declaring w x h, with no pixel
+// data behind it.
+func PNGHeader(w, h uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+
+	buf := bytes.NewBufferString("\x89PNG\r\n\x1a\n")
+	binary.Write(buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.WriteString("IHDR")
+	buf.Write(ihdr)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("IHDR"), ihdr...)))
+	return buf.Bytes()
+}
+
+// VP8L is a lossless WebP bitstream header declaring w x h, with no pixel
+// data behind it.
+func VP8L(w, h uint32) []byte {
+	bits := (w - 1) | (h-1)<<14 | 1<<28 // alpha hint, version 0
+	out := []byte{0x2f, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(out[1:], bits)
+	return out
+}
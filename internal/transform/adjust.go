@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Grayscale converts to luma using the Rec. 601 weights.
+type Grayscale struct{}
+
+func parseGrayscale(args []string) (Operation, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("takes no arguments")
+	}
+	return Grayscale{}, nil
+}
+
+func (Grayscale) String() string { return "gs" }
+
+func (Grayscale) Apply(img image.Image) image.Image {
+	return mapColors(img, func(r, g, b float64) (float64, float64, float64) {
+		y := luma(r, g, b)
+		return y, y, y
+	})
+}
+
+// Adjustment is a brightness, contrast or saturation change of -100 to 100
+// percent.
+type Adjustment struct {
+	Kind  string // "br", "co" or "sat"
+	Value int
+}
+
+func parseAdjustment(kind string) parser {
+	return func(args []string) (Operation, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected a value from -100 to 100")
+		}
+		v, err := intArg(args[0], -100, 100)
+		if err != nil {
+			return nil, fmt.Errorf("value %v", err)
+		}
+		if v == 0 {
+			return nil, nil
+		}
+		return &Adjustment{Kind: kind, Value: v}, nil
+	}
+}
+
+func (a *Adjustment) String() string {
+	return a.Kind + ":" + strconv.Itoa(a.Value)
+}
+
+func (a *Adjustment) Apply(img image.Image) image.Image {
+	v := float64(a.Value) / 100
+	switch a.Kind {
+	case "br":
+		offset := v * 255
+		return mapColors(img, func(r, g, b float64) (float64, float64, float64) {
+			return r + offset, g + offset, b + offset
+		})
+	case "co":
+		factor := 1 + v
+		return mapColors(img, func(r, g, b float64) (float64, float64, float64) {
+			return (r-128)*factor + 128, (g-128)*factor + 128, (b-128)*factor + 128
+		})
+	default:
+		factor := 1 + v
+		return mapColors(img, func(r, g, b float64) (float64, float64, float64) {
+			y := luma(r, g, b)
+			return y + (r-y)*factor, y + (g-y)*factor, y + (b-y)*factor
+		})
+	}
+}
+
+func luma(r, g, b float64) float64 {
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+// mapColors applies fn to the straight (non-premultiplied) color of every
+// pixel, leaving alpha untouched.
+func mapColors(img image.Image, fn func(r, g, b float64) (float64, float64, float64)) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Copy(dst, image.Point{}, img, b, draw.Src, nil)
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		r, g, bl := fn(float64(dst.Pix[i]), float64(dst.Pix[i+1]), float64(dst.Pix[i+2]))
+		dst.Pix[i] = clamp8(r)
+		dst.Pix[i+1] = clamp8(g)
+		dst.Pix[i+2] = clamp8(bl)
+	}
+	return dst
+}
@@ -0,0 +1,85 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+var gravities = map[string]bool{
+	"center": true, "n": true, "s": true, "e": true, "w": true,
+	"ne": true, "nw": true, "se": true, "sw": true,
+}
+
+// Crop cuts a Width x Height region anchored at Gravity. Regions larger than
+// the image are clamped to it.
+type Crop struct {
+	Width   int
+	Height  int
+	Gravity string
+}
+
+// c:<width>:<height>[:<gravity>]
+func parseCrop(args []string) (Operation, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expected width:height[:gravity]")
+	}
+	w, err := intArg(args[0], 1, MaxDimension)
+	if err != nil {
+		return nil, fmt.Errorf("width %v", err)
+	}
+	h, err := intArg(args[1], 1, MaxDimension)
+	if err != nil {
+		return nil, fmt.Errorf("height %v", err)
+	}
+	gravity := "center"
+	if len(args) == 3 {
+		gravity = strings.ToLower(args[2])
+		if !gravities[gravity] {
+			return nil, fmt.Errorf("unknown gravity %q", args[2])
+		}
+	}
+	return &Crop{Width: w, Height: h, Gravity: gravity}, nil
+}
+
+func (c *Crop) String() string {
+	return fmt.Sprintf("c:%d:%d:%s", c.Width, c.Height, c.Gravity)
+}
+
+func (c *Crop) scaled(dpr float64) Operation {
+	return &Crop{Width: scaleDimension(c.Width, dpr), Height: scaleDimension(c.Height, dpr), Gravity: c.Gravity}
+}
+
+func (c *Crop) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := min(c.Width, b.Dx()), min(c.Height, b.Dy())
+	p := anchor(b, w, h, c.Gravity, 0)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Copy(dst, image.Point{}, img, image.Rect(p.X, p.Y, p.X+w, p.Y+h), draw.Src, nil)
+	return dst
+}
+
+// anchor returns the top-left corner of a w x h box placed inside b at the
+// given gravity, inset by margin from the edges it is anchored to.
+func anchor(b image.Rectangle, w, h int, gravity string, margin int) image.Point {
+	x := b.Min.X + (b.Dx()-w)/2
+	y := b.Min.Y + (b.Dy()-h)/2
+	if gravity != "center" {
+		for _, g := range gravity {
+			switch g {
+			case 'n':
+				y = b.Min.Y + margin
+			case 's':
+				y = b.Max.Y - h - margin
+			case 'w':
+				x = b.Min.X + margin
+			case 'e':
+				x = b.Max.X - w - margin
+			}
+		}
+	}
+	return image.Point{X: x, Y: y}
+}
@@ -0,0 +1,176 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const maxBlurSigma = 50
+
+// Blur applies a Gaussian blur with the given standard deviation in pixels.
+type Blur struct {
+	Sigma float64
+}
+
+// bl:<sigma>
+func parseBlur(args []string) (Operation, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected sigma")
+	}
+	sigma, err := floatArg(args[0], 0, maxBlurSigma)
+	if err != nil {
+		return nil, fmt.Errorf("sigma %v", err)
+	}
+	if sigma == 0 {
+		return nil, nil
+	}
+	return &Blur{Sigma: sigma}, nil
+}
+
+func (b *Blur) String() string {
+	return "bl:" + formatFloat(b.Sigma)
+}
+
+func (b *Blur) Apply(img image.Image) image.Image {
+	return gaussianBlur(toRGBA(img), b.Sigma)
+}
+
+// toRGBA returns a copy of img as an RGBA image with its origin at (0, 0).
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Copy(dst, image.Point{}, img, b, draw.Src, nil)
+	return dst
+}
+
+// gaussianBlur blurs src with a separable kernel, clamping at the edges.
+func gaussianBlur(src *image.RGBA, sigma float64) *image.RGBA {
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	tmp := image.NewRGBA(src.Bounds())
+	dst := image.NewRGBA(src.Bounds())
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for k, weight := range kernel {
+				sx := min(max(x+k-radius, 0), w-1)
+				i := src.PixOffset(sx, y)
+				for c := 0; c < 4; c++ {
+					acc[c] += float64(src.Pix[i+c]) * weight
+				}
+			}
+			i := tmp.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				tmp.Pix[i+c] = clamp8(acc[c])
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for k, weight := range kernel {
+				sy := min(max(y+k-radius, 0), h-1)
+				i := tmp.PixOffset(x, sy)
+				for c := 0; c < 4; c++ {
+					acc[c] += float64(tmp.Pix[i+c]) * weight
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = clamp8(acc[c])
+			}
+		}
+	}
+	return dst
+}
+
+func clamp8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+const (
+	maxSharpenAmount    = 5
+	maxSharpenSigma     = 10
+	defaultSharpenSigma = 1
+)
+
+// Sharpen is an unsharp mask: the difference between the image and a blurred
+// copy, scaled by Amount, is added back wherever it exceeds Threshold.
+type Sharpen struct {
+	Amount    float64
+	Sigma     float64
+	Threshold int
+}
+
+// sh:<amount>[:<sigma>[:<threshold>]]
+func parseSharpen(args []string) (Operation, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("expected amount[:sigma[:threshold]]")
+	}
+	amount, err := floatArg(args[0], 0, maxSharpenAmount)
+	if err != nil {
+		return nil, fmt.Errorf("amount %v", err)
+	}
+	s := &Sharpen{Amount: amount, Sigma: defaultSharpenSigma}
+	if len(args) > 1 {
+		if s.Sigma, err = floatArg(args[1], 0.1, maxSharpenSigma); err != nil {
+			return nil, fmt.Errorf("sigma %v", err)
+		}
+	}
+	if len(args) > 2 {
+		if s.Threshold, err = intArg(args[2], 0, 255); err != nil {
+			return nil, fmt.Errorf("threshold %v", err)
+		}
+	}
+	if amount == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func (s *Sharpen) String() string {
+	return fmt.Sprintf("sh:%s:%s:%d", formatFloat(s.Amount), formatFloat(s.Sigma), s.Threshold)
+}
+
+func (s *Sharpen) Apply(img image.Image) image.Image {
+	src := toRGBA(img)
+	blurred := gaussianBlur(src, s.Sigma)
+	dst := image.NewRGBA(src.Bounds())
+
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := src.Pix[i+3]
+		for c := 0; c < 3; c++ {
+			v := float64(src.Pix[i+c])
+			diff := v - float64(blurred.Pix[i+c])
+			if math.Abs(diff) > float64(s.Threshold) {
+				v += diff * s.Amount
+			}
+			// Premultiplied color must not exceed alpha.
+			dst.Pix[i+c] = min(clamp8(v), a)
+		}
+		dst.Pix[i+3] = a
+	}
+	return dst
+}
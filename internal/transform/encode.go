@@ -0,0 +1,77 @@
+package transform
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chai2010/webp"
+)
+
+const defaultJPEGQuality = 85
+
+var contentTypes = map[string]string{
+	"webp": "image/webp",
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+}
+
+// Formats returns the supported output formats, WebP first.
+func Formats() []string {
+	formats := []string{"webp"}
+	for f := range contentTypes {
+		if f != "webp" {
+			formats = append(formats, f)
+		}
+	}
+	sort.Strings(formats[1:])
+	return formats
+}
+
+// SupportsFormat reports whether the output format can be encoded.
+func SupportsFormat(format string) bool {
+	_, ok := contentTypes[format]
+	return ok
+}
+
+// ContentType returns the MIME type of an output format.
+func ContentType(format string) string {
+	if ct, ok := contentTypes[format]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func normalizeFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// Encode writes img in the given format. Quality 0 means lossless for WebP
+// and the default quality for JPEG; PNG and GIF ignore it.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch normalizeFormat(format) {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+
+	if quality == 0 {
+		return webp.Encode(w, img, &webp.Options{Lossless: true})
+	}
+	return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+}
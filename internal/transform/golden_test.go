@@ -0,0 +1,204 @@
+package transform
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+// goldenTolerance allows for floating point differences between platforms.
+const goldenTolerance = 2
+
+func loadPNG(t *testing.T, path string) *image.NRGBA {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Copy(dst, image.Point{}, img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// goldenWatermarks resolves "logo", a translucent two-tone badge, and "bar",
+// a narrow strip taller than the input.
+func goldenWatermarks(id string) (image.Image, error) {
+	var img *image.NRGBA
+	switch id {
+	case "logo":
+		img = image.NewNRGBA(image.Rect(0, 0, 40, 20))
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 40; x++ {
+				c := color.NRGBA{R: 255, G: 200, A: 255}
+				if (x/10+y/10)%2 == 1 {
+					c = color.NRGBA{B: 255, A: 128}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	case "bar":
+		img = image.NewNRGBA(image.Rect(0, 0, 4, 400))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i+1], img.Pix[i+3] = 255, 255
+		}
+	default:
+		return nil, errors.New("not found")
+	}
+	return img, nil
+}
+
+func TestGolden(t *testing.T) {
+	input := loadPNG(t, filepath.Join("testdata", "input.png"))
+
+	tests := []struct {
+		name  string
+		chain string
+	}{
+		{"blur", "bl:1.5"},
+		{"sharpen", "sh:1.5:1"},
+		{"sharpen_threshold", "sh:2:1:20"},
+		{"grayscale", "gs"},
+		{"brightness_up", "br:40"},
+		{"brightness_down", "br:-40"},
+		{"contrast", "co:50"},
+		{"saturation_down", "sat:-100"},
+		{"saturation_up", "sat:60"},
+		{"rotate_90", "rot:90"},
+		{"rotate_180", "rot:180"},
+		{"rotate_270", "rot:270"},
+		{"flip_h", "fl:h"},
+		{"flip_v", "fl:v"},
+		{"flip_hv", "fl:hv"},
+		{"chain", "rot:90/fl:h/sat:-50/bl:1"},
+		{"watermark", "wm:logo:se:60:30"},
+		{"watermark_tall", "wm:bar:nw:100:100"},
+		{"watermark_tile", "wm:logo:tile:50:10"},
+		{"watermark_tile_tiny", "wm:logo:tile:80:1"},
+		{"text", "tx:Golden:sw:80:14"},
+		{"text_center", "tx:Hello World:center"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := Parse(tt.chain)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.chain, err)
+			}
+			if pipeline, err = pipeline.Bind(goldenWatermarks); err != nil {
+				t.Fatal(err)
+			}
+			out := pipeline.Apply(input)
+			got := image.NewNRGBA(image.Rect(0, 0, out.Bounds().Dx(), out.Bounds().Dy()))
+			draw.Copy(got, image.Point{}, out, out.Bounds(), draw.Src, nil)
+
+			path := filepath.Join("testdata", "golden", tt.name+".png")
+			if *update {
+				f, err := os.Create(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if err := png.Encode(f, got); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want := loadPNG(t, path)
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+			}
+			for i := range got.Pix {
+				d := int(got.Pix[i]) - int(want.Pix[i])
+				if d < -goldenTolerance || d > goldenTolerance {
+					x, y := (i/4)%got.Bounds().Dx(), (i/4)/got.Bounds().Dx()
+					t.Fatalf("pixel (%d,%d) channel %d = %d, want %d", x, y, i%4, got.Pix[i], want.Pix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRotateFlipExact(t *testing.T) {
+	input := loadPNG(t, filepath.Join("testdata", "input.png"))
+	w, h := input.Bounds().Dx(), input.Bounds().Dy()
+	// Operations work on premultiplied RGBA, so compare against that
+	ref := toRGBA(input)
+
+	// Each case maps an output pixel back to its source pixel
+	tests := []struct {
+		chain  string
+		w, h   int
+		source func(x, y int) (int, int)
+	}{
+		{"rot:90", h, w, func(x, y int) (int, int) { return y, h - 1 - x }},
+		{"rot:180", w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }},
+		{"rot:270", h, w, func(x, y int) (int, int) { return w - 1 - y, x }},
+		{"fl:h", w, h, func(x, y int) (int, int) { return w - 1 - x, y }},
+		{"fl:v", w, h, func(x, y int) (int, int) { return x, h - 1 - y }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.chain, func(t *testing.T) {
+			pipeline, err := Parse(tt.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := pipeline.Apply(input)
+			if b := out.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+			for y := 0; y < tt.h; y++ {
+				for x := 0; x < tt.w; x++ {
+					sx, sy := tt.source(x, y)
+					gr, gg, gb, ga := out.At(out.Bounds().Min.X+x, out.Bounds().Min.Y+y).RGBA()
+					wr, wg, wb, wa := ref.At(sx, sy).RGBA()
+					if gr != wr || gg != wg || gb != wb || ga != wa {
+						t.Fatalf("pixel (%d,%d) differs from source (%d,%d)", x, y, sx, sy)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEffectBounds(t *testing.T) {
+	invalid := []string{
+		"bl:-1", "bl:51", "bl:x",
+		"sh:-1", "sh:11", "sh:1:0", "sh:1:1:256",
+		"br:101", "br:-101", "co:200", "sat:-101",
+		"rot:45", "rot:x",
+		"fl:x", "fl",
+		"gs:1",
+	}
+	for _, chain := range invalid {
+		if _, err := Parse(chain); !errors.Is(err, ErrInvalidOperation) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidOperation", chain, err)
+		}
+	}
+
+	// Zero-strength effects are dropped, so equivalent chains share a cache key
+	for _, chain := range []string{"bl:0", "sh:0", "br:0", "rot:0", "rot:360"} {
+		p, err := Parse(chain)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", chain, err)
+		}
+		if len(p.Ops) != 0 {
+			t.Errorf("Parse(%q) kept %d operations, want none", chain, len(p.Ops))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+)
+
+// Rotate turns the image clockwise by a multiple of 90 degrees.
+type Rotate struct {
+	Degrees int
+}
+
+// rot:<90|180|270>
+func parseRotate(args []string) (Operation, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected degrees")
+	}
+	deg, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an integer", args[0])
+	}
+	deg = ((deg % 360) + 360) % 360
+	if deg%90 != 0 {
+		return nil, fmt.Errorf("degrees must be a multiple of 90")
+	}
+	if deg == 0 {
+		return nil, nil
+	}
+	return &Rotate{Degrees: deg}, nil
+}
+
+func (r *Rotate) String() string {
+	return "rot:" + strconv.Itoa(r.Degrees)
+}
+
+func (r *Rotate) Apply(img image.Image) image.Image {
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	var dst *image.RGBA
+	var mapPoint func(x, y int) (int, int)
+	switch r.Degrees {
+	case 90:
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+		mapPoint = func(x, y int) (int, int) { return h - 1 - y, x }
+	case 180:
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+		mapPoint = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	default:
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+		mapPoint = func(x, y int) (int, int) { return y, w - 1 - x }
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := mapPoint(x, y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// Flip mirrors the image horizontally, vertically or both.
+type Flip struct {
+	Horizontal bool
+	Vertical   bool
+}
+
+// fl:<h|v|hv>
+func parseFlip(args []string) (Operation, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected h, v or hv")
+	}
+	f := &Flip{}
+	switch args[0] {
+	case "h":
+		f.Horizontal = true
+	case "v":
+		f.Vertical = true
+	case "hv", "vh":
+		f.Horizontal, f.Vertical = true, true
+	default:
+		return nil, fmt.Errorf("expected h, v or hv, got %q", args[0])
+	}
+	return f, nil
+}
+
+func (f *Flip) String() string {
+	switch {
+	case f.Horizontal && f.Vertical:
+		return "fl:hv"
+	case f.Horizontal:
+		return "fl:h"
+	default:
+		return "fl:v"
+	}
+}
+
+func (f *Flip) Apply(img image.Image) image.Image {
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(src.Bounds())
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			if f.Horizontal {
+				dx = w - 1 - x
+			}
+			if f.Vertical {
+				dy = h - 1 - y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
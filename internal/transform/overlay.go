@@ -0,0 +1,274 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultOverlayGravity   = "se"
+	defaultWatermarkOpacity = 50
+	defaultWatermarkScale   = 25
+	defaultTextOpacity      = 70
+	minTextSize             = 6
+	maxTextSize             = 200
+	maxTextLength           = 100
+	// minTileSize bounds the number of tiles a tiny watermark is drawn as
+	minTileSize = 16
+)
+
+// Watermark composites a stored image over the input. Gravity is a crop
+// gravity or "tile" to repeat the watermark across the whole image; Scale is
+// the watermark width as a percentage of the image width.
+type Watermark struct {
+	ID      string
+	Gravity string
+	Opacity int
+	Scale   int
+
+	// img is loaded by Pipeline.Bind.
+	img image.Image
+}
+
+// wm:<id>[:<gravity|tile>[:<opacity>[:<scale>]]]
+func parseWatermark(args []string) (Operation, error) {
+	if len(args) < 1 || len(args) > 4 {
+		return nil, fmt.Errorf("expected id[:gravity[:opacity[:scale]]]")
+	}
+	if !validID(args[0]) {
+		return nil, fmt.Errorf("invalid watermark ID %q", args[0])
+	}
+	wm := &Watermark{ID: args[0], Gravity: defaultOverlayGravity, Opacity: defaultWatermarkOpacity, Scale: defaultWatermarkScale}
+
+	var err error
+	if len(args) > 1 {
+		wm.Gravity = strings.ToLower(args[1])
+		if wm.Gravity != "tile" && !gravities[wm.Gravity] {
+			return nil, fmt.Errorf("unknown gravity %q", args[1])
+		}
+	}
+	if len(args) > 2 {
+		if wm.Opacity, err = intArg(args[2], 1, 100); err != nil {
+			return nil, fmt.Errorf("opacity %v", err)
+		}
+	}
+	if len(args) > 3 {
+		if wm.Scale, err = intArg(args[3], 1, 100); err != nil {
+			return nil, fmt.Errorf("scale %v", err)
+		}
+	}
+	return wm, nil
+}
+
+func (wm *Watermark) String() string {
+	return fmt.Sprintf("wm:%s:%s:%d:%d", wm.ID, wm.Gravity, wm.Opacity, wm.Scale)
+}
+
+func (wm *Watermark) Apply(img image.Image) image.Image {
+	dst := toRGBA(img)
+	if wm.img == nil {
+		return dst
+	}
+
+	b := dst.Bounds()
+	mb := wm.img.Bounds()
+	w := max(b.Dx()*wm.Scale/100, 1)
+	h := max(mb.Dy()*w/max(mb.Dx(), 1), 1)
+	if h > b.Dy() {
+		// A tall watermark is fitted to the image height instead
+		h = b.Dy()
+		w = max(mb.Dx()*h/max(mb.Dy(), 1), 1)
+	}
+	mark := scaleTo(wm.img, mb, w, h)
+	mask := opacityMask(wm.Opacity)
+
+	if wm.Gravity != "tile" {
+		p := anchor(b, w, h, wm.Gravity, overlayMargin(b))
+		draw.DrawMask(dst, image.Rect(p.X, p.Y, p.X+w, p.Y+h), mark, image.Point{}, mask, image.Point{}, draw.Over)
+		return dst
+	}
+
+	// Tiles are separated by half a watermark in each direction, and start
+	// at least minTileSize pixels apart.
+	stepX, stepY := max(w+w/2, minTileSize), max(h+h/2, minTileSize)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			draw.DrawMask(dst, image.Rect(x, y, x+w, y+h), mark, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+	return dst
+}
+
+// Text draws a single line of white text with a dark shadow. Size is the font
+// size in pixels, 0 to derive it from the image height.
+type Text struct {
+	Text    string
+	Gravity string
+	Opacity int
+	Size    int
+}
+
+// tx:<text>[:<gravity>[:<opacity>[:<size>]]]
+func parseText(args []string) (Operation, error) {
+	if len(args) < 1 || len(args) > 4 {
+		return nil, fmt.Errorf("expected text[:gravity[:opacity[:size]]]")
+	}
+	text := strings.TrimSpace(args[0])
+	if text == "" || len([]rune(text)) > maxTextLength {
+		return nil, fmt.Errorf("text must be 1 to %d characters", maxTextLength)
+	}
+	for _, r := range text {
+		if !unicode.IsPrint(r) {
+			return nil, fmt.Errorf("text contains unprintable characters")
+		}
+	}
+	t := &Text{Text: text, Gravity: defaultOverlayGravity, Opacity: defaultTextOpacity}
+
+	var err error
+	if len(args) > 1 {
+		t.Gravity = strings.ToLower(args[1])
+		if !gravities[t.Gravity] {
+			return nil, fmt.Errorf("unknown gravity %q", args[1])
+		}
+	}
+	if len(args) > 2 {
+		if t.Opacity, err = intArg(args[2], 1, 100); err != nil {
+			return nil, fmt.Errorf("opacity %v", err)
+		}
+	}
+	if len(args) > 3 {
+		if t.Size, err = intArg(args[3], 0, maxTextSize); err != nil {
+			return nil, fmt.Errorf("size %v", err)
+		}
+		if t.Size != 0 && t.Size < minTextSize {
+			return nil, fmt.Errorf("size must be 0 or at least %d", minTextSize)
+		}
+	}
+	return t, nil
+}
+
+func (t *Text) String() string {
+	return fmt.Sprintf("tx:%s:%s:%d:%d", t.Text, t.Gravity, t.Opacity, t.Size)
+}
+
+func (t *Text) scaled(dpr float64) Operation {
+	scaled := *t
+	scaled.Size = min(scaleDimension(t.Size, dpr), maxTextSize)
+	return &scaled
+}
+
+func (t *Text) Apply(img image.Image) image.Image {
+	dst := toRGBA(img)
+	b := dst.Bounds()
+
+	size := t.Size
+	if size == 0 {
+		size = min(max(b.Dy()/20, 10), maxTextSize)
+	}
+	face, err := textFace(size)
+	if err != nil {
+		return dst
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	shadow := max(size/16, 1)
+	w := font.MeasureString(face, t.Text).Ceil() + shadow
+	h := (metrics.Ascent + metrics.Descent).Ceil() + shadow
+
+	// Render the label on its own canvas so opacity applies to text and
+	// shadow together.
+	label := image.NewRGBA(image.Rect(0, 0, w, h))
+	d := &font.Drawer{Dst: label, Face: face}
+	d.Src = image.NewUniform(color.RGBA{0, 0, 0, 160})
+	d.Dot = fixed.P(shadow, metrics.Ascent.Ceil()+shadow)
+	d.DrawString(t.Text)
+	d.Src = image.White
+	d.Dot = fixed.P(0, metrics.Ascent.Ceil())
+	d.DrawString(t.Text)
+
+	p := anchor(b, w, h, t.Gravity, overlayMargin(b))
+	draw.DrawMask(dst, image.Rect(p.X, p.Y, p.X+w, p.Y+h), label, image.Point{}, opacityMask(t.Opacity), image.Point{}, draw.Over)
+	return dst
+}
+
+var (
+	fontOnce sync.Once
+	fontData *opentype.Font
+	fontErr  error
+)
+
+// textFace returns a face of the embedded Go Regular font.
+func textFace(size int) (font.Face, error) {
+	fontOnce.Do(func() {
+		fontData, fontErr = opentype.Parse(goregular.TTF)
+	})
+	if fontErr != nil {
+		return nil, fontErr
+	}
+	return opentype.NewFace(fontData, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+}
+
+func opacityMask(opacity int) image.Image {
+	return image.NewUniform(color.Alpha{A: uint8(opacity * 255 / 100)})
+}
+
+// overlayMargin keeps anchored overlays off the image edge.
+func overlayMargin(b image.Rectangle) int {
+	return min(b.Dx(), b.Dy()) / 50
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolver loads an image referenced by an operation, such as a watermark.
+type Resolver func(id string) (image.Image, error)
+
+// Bind returns a copy of the pipeline with referenced images loaded. The
+// receiver is left untouched so parsed presets can be shared.
+func (p *Pipeline) Bind(load Resolver) (*Pipeline, error) {
+	bound := *p
+	bound.Ops = make([]Operation, len(p.Ops))
+	for i, op := range p.Ops {
+		bound.Ops[i] = op
+		wm, ok := op.(*Watermark)
+		if !ok {
+			continue
+		}
+		img, err := load(wm.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: watermark %q: %v", ErrInvalidOperation, wm.ID, err)
+		}
+		resolved := *wm
+		resolved.img = img
+		bound.Ops[i] = &resolved
+	}
+	return &bound, nil
+}
+
+// Then returns a pipeline running p's operations followed by next's. Output
+// options come from p.
+func (p *Pipeline) Then(next *Pipeline) *Pipeline {
+	combined := *p
+	combined.Ops = append(append([]Operation{}, p.Ops...), next.Ops...)
+	return &combined
+}
@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Presets maps preset names to parsed pipelines.
+type Presets map[string]*Pipeline
+
+// ParsePresets parses a comma-separated list of name=chain definitions, e.g.
+// "thumb=rs:fill:150:150/q:80,hero@2x=rs:2400:0". Names are case-insensitive
+// and may contain letters, digits, "-", "_", "." and "@".
+func ParsePresets(spec string) (Presets, error) {
+	presets := Presets{}
+	for _, def := range strings.Split(spec, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+
+		name, chain, ok := strings.Cut(def, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !validPresetName(name) {
+			return nil, fmt.Errorf("invalid preset definition %q", def)
+		}
+		if _, dup := presets[name]; dup {
+			return nil, fmt.Errorf("duplicate preset %q", name)
+		}
+
+		pipeline, err := Parse(strings.TrimSpace(chain))
+		if err != nil {
+			return nil, fmt.Errorf("preset %q: %w", name, err)
+		}
+		presets[name] = pipeline
+	}
+	return presets, nil
+}
+
+// Get looks up a preset by name.
+func (p Presets) Get(name string) (*Pipeline, bool) {
+	pipeline, ok := p[strings.ToLower(name)]
+	return pipeline, ok
+}
+
+// Names returns the preset names in sorted order.
+func (p Presets) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validPresetName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
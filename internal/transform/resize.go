@@ -0,0 +1,124 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Resize modes.
+const (
+	ResizeFit   = "fit"   // scale to fit inside the box, keeping aspect ratio
+	ResizeFill  = "fill"  // scale to cover the box and crop the overflow
+	ResizeForce = "force" // scale to exactly the box, ignoring aspect ratio
+)
+
+// Resize scales an image. A zero width or height is derived from the aspect
+// ratio. Images are never enlarged beyond their original size.
+type Resize struct {
+	Mode   string
+	Width  int
+	Height int
+}
+
+// rs:<mode>:<width>:<height>, rs:<width>:<height> (fit)
+func parseResize(args []string) (Operation, error) {
+	mode := ResizeFit
+	if len(args) == 3 {
+		mode, args = strings.ToLower(args[0]), args[1:]
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected [mode:]width:height")
+	}
+	switch mode {
+	case ResizeFit, ResizeFill, ResizeForce:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (use fit, fill or force)", mode)
+	}
+
+	w, err := intArg(args[0], 0, MaxDimension)
+	if err != nil {
+		return nil, fmt.Errorf("width %v", err)
+	}
+	h, err := intArg(args[1], 0, MaxDimension)
+	if err != nil {
+		return nil, fmt.Errorf("height %v", err)
+	}
+	if w == 0 && h == 0 {
+		return nil, nil
+	}
+	// With one dimension free, every mode behaves like fit
+	if w == 0 || h == 0 {
+		mode = ResizeFit
+	}
+	return &Resize{Mode: mode, Width: w, Height: h}, nil
+}
+
+func (r *Resize) String() string {
+	return fmt.Sprintf("rs:%s:%d:%d", r.Mode, r.Width, r.Height)
+}
+
+func (r *Resize) scaled(dpr float64) Operation {
+	return &Resize{Mode: r.Mode, Width: scaleDimension(r.Width, dpr), Height: scaleDimension(r.Height, dpr)}
+}
+
+func (r *Resize) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return img
+	}
+
+	tw, th := r.Width, r.Height
+	switch {
+	case tw == 0:
+		tw = int(math.Round(float64(sw) * float64(th) / float64(sh)))
+	case th == 0:
+		th = int(math.Round(float64(sh) * float64(tw) / float64(sw)))
+	}
+
+	switch r.Mode {
+	case ResizeFit:
+		scale := math.Min(float64(tw)/float64(sw), float64(th)/float64(sh))
+		if scale >= 1 {
+			return img
+		}
+		return scaleTo(img, b, max(1, int(math.Round(float64(sw)*scale))), max(1, int(math.Round(float64(sh)*scale))))
+	case ResizeFill:
+		// Never upscale: shrink the box to the source if needed
+		if tw > sw || th > sh {
+			shrink := math.Min(float64(sw)/float64(tw), float64(sh)/float64(th))
+			tw = max(1, int(float64(tw)*shrink))
+			th = max(1, int(float64(th)*shrink))
+		}
+		// Crop the source to the target aspect ratio around the center
+		crop := b
+		if float64(sw)*float64(th) > float64(sh)*float64(tw) {
+			cw := int(math.Round(float64(sh) * float64(tw) / float64(th)))
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := int(math.Round(float64(sw) * float64(th) / float64(tw)))
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+		return scaleTo(img, crop, tw, th)
+	}
+
+	// force
+	return scaleTo(img, b, min(tw, sw), min(th, sh))
+}
+
+func scaleTo(img image.Image, src image.Rectangle, w, h int) image.Image {
+	if src.Dx() == w && src.Dy() == h {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Copy(dst, image.Point{}, img, src, draw.Src, nil)
+		return dst
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,262 @@
+// Package transform parses and executes image transformation chains such as
+// "rs:fill:300:200/q:80/f:png/blur:2".
+//
+// A chain is a list of operations separated by "/", each written as a name
+// followed by colon-separated arguments. Pixel operations run in the order
+// given; output options (frame, quality and format) apply to the final
+// encode regardless of where they appear.
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidOperation = errors.New("invalid transformation")
+
+const (
+	maxOperations = 16
+	// MaxDimension bounds every width or height a chain may request.
+	MaxDimension = 8192
+	// MaxFrames bounds the frame a chain may select.
+	MaxFrames = 10000
+)
+
+// Operation is a single pixel operation in a pipeline.
+type Operation interface {
+	// Apply returns the transformed image; the input must not be modified.
+	Apply(img image.Image) image.Image
+	// String returns the canonical form used in cache keys.
+	String() string
+}
+
+// Pipeline is a parsed transformation chain.
+type Pipeline struct {
+	Ops     []Operation
+	Format  string // output format, "" for the WebP default
+	Quality int    // 1-100, 0 for lossless
+	// Frame selects a single frame (1-based) of an animated image, 0 keeps
+	// the animation. Formats that cannot animate always use a single frame.
+	Frame int
+}
+
+type parser func(args []string) (Operation, error)
+
+// parsers maps every accepted operation name, including aliases, to its parser.
+var parsers = map[string]parser{}
+
+func register(p parser, names ...string) {
+	for _, name := range names {
+		parsers[name] = p
+	}
+}
+
+func init() {
+	register(parseResize, "rs", "resize")
+	register(parseCrop, "c", "crop")
+	register(parseBlur, "bl", "blur")
+	register(parseSharpen, "sh", "sharpen")
+	register(parseGrayscale, "gs", "grayscale", "greyscale")
+	register(parseAdjustment("br"), "br", "brightness")
+	register(parseAdjustment("co"), "co", "contrast")
+	register(parseAdjustment("sat"), "sat", "saturation")
+	register(parseRotate, "rot", "rotate")
+	register(parseFlip, "fl", "flip")
+	register(parseWatermark, "wm", "watermark")
+	register(parseText, "tx", "text")
+}
+
+// Parse parses a chain into a pipeline.
+func Parse(chain string) (*Pipeline, error) {
+	p := &Pipeline{}
+	chain = strings.Trim(chain, "/")
+	if chain == "" {
+		return p, nil
+	}
+
+	parts := strings.Split(chain, "/")
+	if len(parts) > maxOperations {
+		return nil, fmt.Errorf("%w: at most %d operations are allowed", ErrInvalidOperation, maxOperations)
+	}
+
+	for _, part := range parts {
+		fields := strings.Split(part, ":")
+		name, args := strings.ToLower(fields[0]), fields[1:]
+
+		switch name {
+		case "q", "quality":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%w: quality takes one argument", ErrInvalidOperation)
+			}
+			q, err := intArg(args[0], 1, 100)
+			if err != nil {
+				return nil, fmt.Errorf("%w: quality %v", ErrInvalidOperation, err)
+			}
+			p.Quality = q
+			continue
+		case "fr", "frame":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%w: frame takes one argument", ErrInvalidOperation)
+			}
+			frame, err := intArg(args[0], 1, MaxFrames)
+			if err != nil {
+				return nil, fmt.Errorf("%w: frame %v", ErrInvalidOperation, err)
+			}
+			p.Frame = frame
+			continue
+		case "f", "format":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("%w: format takes one argument", ErrInvalidOperation)
+			}
+			format := normalizeFormat(args[0])
+			if !SupportsFormat(format) {
+				return nil, fmt.Errorf("%w: unsupported output format %q (use %s)", ErrInvalidOperation, args[0], strings.Join(Formats(), ", "))
+			}
+			p.Format = format
+			continue
+		}
+
+		parse, ok := parsers[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidOperation, fields[0])
+		}
+		op, err := parse(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOperation, name, err)
+		}
+		if op != nil {
+			p.Ops = append(p.Ops, op)
+		}
+	}
+
+	if p.Format == "webp" {
+		p.Format = ""
+	}
+	return p, nil
+}
+
+// Canonical returns a normalized chain: aliases resolved, defaults filled in,
+// no-op operations dropped and output options last. Equivalent chains produce
+// the same string, so it is safe to use as a cache key.
+func (p *Pipeline) Canonical() string {
+	parts := make([]string, 0, len(p.Ops)+2)
+	for _, op := range p.Ops {
+		parts = append(parts, op.String())
+	}
+	if p.Frame > 0 {
+		parts = append(parts, "fr:"+strconv.Itoa(p.Frame))
+	}
+	if p.Quality > 0 {
+		parts = append(parts, "q:"+strconv.Itoa(p.Quality))
+	}
+	if p.Format != "" {
+		parts = append(parts, "f:"+p.Format)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Apply runs the pixel operations in order.
+func (p *Pipeline) Apply(img image.Image) image.Image {
+	for _, op := range p.Ops {
+		img = op.Apply(img)
+	}
+	return img
+}
+
+// Animated reports whether the pipeline can keep an animation: no single
+// frame is selected and the output format supports animation.
+func (p *Pipeline) Animated() bool {
+	format := p.OutputFormat()
+	return p.Frame == 0 && (format == "webp" || format == "gif")
+}
+
+// OutputFormat returns the format the pipeline encodes to.
+func (p *Pipeline) OutputFormat() string {
+	if p.Format == "" {
+		return "webp"
+	}
+	return p.Format
+}
+
+func intArg(s string, min, max int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+func floatArg(s string, min, max float64) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%g is out of range [%g, %g]", v, min, max)
+	}
+	return v, nil
+}
+
+// formatFloat renders a parameter without trailing zeros so "2.0" and "2"
+// share a canonical form.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// MaxDPR bounds the device pixel ratio accepted by WithDPR.
+const MaxDPR = 4
+
+// dprSteps are the device pixel ratios rendered. Other values round up to the
+// next step, so clients cannot create a cache entry per fractional dpr.
+var dprSteps = []float64{1, 1.5, 2, 3, MaxDPR}
+
+// scalable is implemented by operations with pixel dimensions.
+type scalable interface {
+	scaled(dpr float64) Operation
+}
+
+// ParseDPR parses a device pixel ratio between 1 and MaxDPR and rounds it up
+// to one of 1, 1.5, 2, 3 or 4.
+func ParseDPR(s string) (float64, error) {
+	dpr, err := floatArg(s, 1, MaxDPR)
+	if err != nil {
+		return 0, fmt.Errorf("%w: dpr %v", ErrInvalidOperation, err)
+	}
+	for _, step := range dprSteps {
+		if dpr <= step {
+			return step, nil
+		}
+	}
+	return MaxDPR, nil
+}
+
+// WithDPR returns a copy of the pipeline with pixel dimensions multiplied by
+// dpr. Resizes still never upscale, so the result is capped at the original
+// resolution. The scaled chain has its own canonical form, which "rs:300:0"
+// at dpr 2 shares with "rs:600:0".
+func (p *Pipeline) WithDPR(dpr float64) *Pipeline {
+	scaledPipeline := *p
+	if dpr == 1 {
+		return &scaledPipeline
+	}
+	scaledPipeline.Ops = make([]Operation, len(p.Ops))
+	for i, op := range p.Ops {
+		if s, ok := op.(scalable); ok {
+			op = s.scaled(dpr)
+		}
+		scaledPipeline.Ops[i] = op
+	}
+	return &scaledPipeline
+}
+
+// scaleDimension multiplies a pixel dimension, bounded by MaxDimension.
+func scaleDimension(v int, dpr float64) int {
+	return min(int(math.Round(float64(v)*dpr)), MaxDimension)
+}
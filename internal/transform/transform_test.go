@@ -0,0 +1,30 @@
+package transform
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDPR(t *testing.T) {
+	tests := map[string]float64{
+		"1":     1,
+		"1.001": 1.5,
+		"1.5":   1.5,
+		"2":     2,
+		"2.625": 3,
+		"3.5":   4,
+		"4":     4,
+	}
+	for in, want := range tests {
+		got, err := ParseDPR(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDPR(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"0.5", "4.01", "x", ""} {
+		if _, err := ParseDPR(in); !errors.Is(err, ErrInvalidOperation) {
+			t.Errorf("ParseDPR(%q) error = %v, want ErrInvalidOperation", in, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	now     = time.Unix(1700000000, 0)
+	current = Key{ID: "2024b", Secret: []byte("new secret")}
+	old     = Key{ID: "2024a", Secret: []byte("old secret")}
+)
+
+func verify(v *Verifier, signed string) error {
+	u, err := url.Parse(signed)
+	if err != nil {
+		return err
+	}
+	return v.Verify(u.EscapedPath(), u.Query(), now)
+}
+
+func sign(t *testing.T, key Key, rawURL string, expires time.Time) string {
+	t.Helper()
+	signed, err := Sign(key, rawURL, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	v := NewVerifier([]Key{current, old})
+	signed := sign(t, current, "https://img.example.com/t/rs:300:0/q:80/photo%20one?w=1", now.Add(time.Hour))
+
+	tests := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"valid", signed, nil},
+		{"bare path", sign(t, current, "/t/rs:300:0/photo", time.Time{}), nil},
+		{"rotated key", sign(t, old, "/t/rs:300:0/photo", time.Time{}), nil},
+		{"other image", strings.Replace(signed, "photo%20one", "photo%20two", 1), ErrInvalidSignature},
+		{"other chain", strings.Replace(signed, "rs:300:0", "rs:3000:0", 1), ErrInvalidSignature},
+		{"changed query", strings.Replace(signed, "w=1", "w=2", 1), ErrInvalidSignature},
+		{"added query", signed + "&extra=1", ErrInvalidSignature},
+		{"extended expiry", strings.Replace(signed, "exp=1700003600", "exp=1800000000", 1), ErrInvalidSignature},
+		{"expired", sign(t, current, "/t/rs:300:0/photo", now.Add(-time.Second)), ErrExpired},
+		{"unknown key", sign(t, Key{ID: "2023", Secret: []byte("retired")}, "/t/rs:300:0/photo", time.Time{}), ErrUnknownKey},
+		{"wrong secret", sign(t, Key{ID: current.ID, Secret: []byte("guess")}, "/t/rs:300:0/photo", time.Time{}), ErrInvalidSignature},
+		{"unsigned", "/t/rs:300:0/photo", ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		if err := verify(v, tt.url); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %s: err = %v, want %v", tt.name, tt.url, err, tt.want)
+		}
+	}
+
+	// Once the old key is dropped its URLs stop working
+	if err := verify(NewVerifier([]Key{current}), sign(t, old, "/t/rs:300:0/photo", time.Time{})); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("retired key: err = %v", err)
+	}
+}
+
+func TestSignReplacesParameters(t *testing.T) {
+	first := sign(t, old, "/p/card/photo", now.Add(time.Hour))
+	resigned := sign(t, current, first, time.Time{})
+
+	u, _ := url.Parse(resigned)
+	q := u.Query()
+	if q.Get(ParamKeyID) != current.ID || q.Has(ParamExpires) || len(q[ParamSignature]) != 1 {
+		t.Fatalf("re-signed URL = %s", resigned)
+	}
+	if err := verify(NewVerifier([]Key{current}), resigned); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" 2024b:new , 2024a:old:with:colons,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "2024b" || string(keys[1].Secret) != "old:with:colons" {
+		t.Errorf("keys = %+v", keys)
+	}
+	for _, spec := range []string{"nosecret", ":secret", "id:", "a:1,a:2"} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}